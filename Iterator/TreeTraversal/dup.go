@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Inserting a key which is already in the tree can mean different things to
+// different users of the tree:
+//
+//	DupReplace - map: the new value replaces the old one (the default)
+//	DupReject  - set: Insert fails with ErrDuplicateKey
+//	DupCount   - multiset: the node counts the copies, keeps the first value
+//	DupList    - multimap: the node keeps every value, in insert order
+//
+// Either way there is still one node per key, so the shape (and balance) of
+// the tree does not change with duplicates. A node with copies is yielded
+// once per copy by the inorder iterators, and counted once per copy by Len
+// and the order statistics.
+type DupPolicy int
+
+const (
+	DupReplace DupPolicy = iota
+	DupReject
+	DupCount
+	DupList
+)
+
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// WithPolicy sets the DupPolicy, it has to be done while the tree is empty:
+//
+//	ms := NewOrderedTree[int, string]().WithPolicy(DupCount)
+func (t *Tree[K, V]) WithPolicy(p DupPolicy) *Tree[K, V] {
+	if t.root != nil {
+		panic("WithPolicy on a non-empty tree")
+	}
+	t.policy = p
+	return t
+}
+
+func (t *Tree[K, V]) insertDup(key K, val V) error {
+	if t.policy == DupReject {
+		return fmt.Errorf("%w: %v", ErrDuplicateKey, key)
+	}
+	t.unshare()
+	n := t.find(key)
+	switch t.policy {
+	case DupReplace:
+		n.Val = val
+		return nil // same keys, so iterators and sizes are not affected
+	case DupList:
+		n.more = append(n.more, val)
+	}
+	n.count++
+	t.mods++
+	t.rebalance(n) // just to fix up the sizes on the way to the root
+	return nil
+}
+
+// DeleteOne removes a single copy of key (the last value added, with
+// DupList), and the key itself once no copies are left.
+func (t *Tree[K, V]) DeleteOne(key K) bool {
+	n := t.find(key)
+	if n == nil {
+		return false
+	}
+	if n.count == 1 {
+		return t.Delete(key)
+	}
+	t.unshare()
+	n = t.find(key)
+	if len(n.more) > 0 {
+		n.more = n.more[:len(n.more)-1]
+	}
+	n.count--
+	t.mods++
+	t.rebalance(n)
+	return true
+}
+
+// Count is how many times the key of n is in the tree.
+func (n *Node[K, V]) Count() int {
+	return n.count
+}
+
+// valueAt gives the value of the i'th copy. With DupCount every copy has
+// the first value.
+func (n *Node[K, V]) valueAt(i int) V {
+	if i == 0 || len(n.more) == 0 {
+		return n.Val
+	}
+	return n.more[i-1]
+}
+
+// Values gives the value of every copy of the key, oldest first.
+func (n *Node[K, V]) Values() []V {
+	vals := make([]V, n.count)
+	for i := range vals {
+		vals[i] = n.valueAt(i)
+	}
+	return vals
+}
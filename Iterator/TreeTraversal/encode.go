@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Saving and loading a tree, plus a Graphviz picture of it for debugging.
+//
+// Both JSON and binary keep the exact shape of the tree (they are pre-order
+// with the children marked), so loading does not have to insert and rebalance
+// everything again. Loading does check that what comes in really is a sorted
+// AVL tree, and rebuilds the Parent links, heights and sizes, so iterators
+// work on a loaded tree straight away.
+//
+// Loading goes into an existing tree because the comparator can't be saved:
+//
+//	t := NewOrderedTree[int, string]()
+//	err := json.Unmarshal(data, t)
+
+var ErrBadTree = errors.New("not a valid tree encoding")
+
+// adopt makes root (whose Left/Right are set, nothing else) the content of t.
+func (t *Tree[K, V]) adopt(root *Node[K, V]) error {
+	var prev *Node[K, V]
+	var fix func(n, parent *Node[K, V]) error
+	fix = func(n, parent *Node[K, V]) error {
+		if n == nil {
+			return nil
+		}
+		n.Parent = parent
+		if !t.validDups(n) {
+			return fmt.Errorf("%w: bad count at key %v", ErrBadTree, n.Key)
+		}
+		if err := fix(n.Left, n); err != nil {
+			return err
+		}
+		if prev != nil && t.cmp(prev.Key, n.Key) >= 0 {
+			return fmt.Errorf("%w: keys %v and %v out of order", ErrBadTree, prev.Key, n.Key)
+		}
+		prev = n
+		if err := fix(n.Right, n); err != nil {
+			return err
+		}
+		n.update()
+		if b := n.balance(); b > 1 || b < -1 {
+			return fmt.Errorf("%w: unbalanced at key %v", ErrBadTree, n.Key)
+		}
+		return nil
+	}
+	if err := fix(root, nil); err != nil {
+		return err
+	}
+	t.root = root
+	t.shared = false
+	t.mods++
+	return nil
+}
+
+// validDups is whether the copies of n are ones the DupPolicy of t could
+// have made, see dup.go.
+func (t *Tree[K, V]) validDups(n *Node[K, V]) bool {
+	switch t.policy {
+	case DupCount:
+		return n.count >= 1 && len(n.more) == 0
+	case DupList:
+		return n.count >= 1 && len(n.more) == n.count-1
+	}
+	return n.count == 1 && len(n.more) == 0
+}
+
+// maxHeight is the most an AVL tree of n nodes can be high. The sparsest AVL
+// tree of height h has sparsest(h-1) + sparsest(h-2) + 1 nodes.
+func maxHeight(n uint64) int {
+	h, a, b := 0, uint64(0), uint64(1) // sparsest(h), sparsest(h+1)
+	for b <= n {
+		h, a, b = h+1, b, a+b+1
+	}
+	return h
+}
+
+// JSON: {"key": .., "val": .., "left": {..}, "right": {..}}, null for empty.
+// Keys with duplicates also have "count" and, with DupList, "more" values.
+
+type jsonNode[K, V any] struct {
+	Key   K               `json:"key"`
+	Val   V               `json:"val"`
+	Count int             `json:"count,omitempty"`
+	More  []V             `json:"more,omitempty"`
+	Left  *jsonNode[K, V] `json:"left,omitempty"`
+	Right *jsonNode[K, V] `json:"right,omitempty"`
+}
+
+func toJSON[K, V any](n *Node[K, V]) *jsonNode[K, V] {
+	if n == nil {
+		return nil
+	}
+	j := &jsonNode[K, V]{Key: n.Key, Val: n.Val, More: n.more, Left: toJSON(n.Left), Right: toJSON(n.Right)}
+	if n.count > 1 {
+		j.Count = n.count
+	}
+	return j
+}
+
+func fromJSON[K, V any](j *jsonNode[K, V]) *Node[K, V] {
+	if j == nil {
+		return nil
+	}
+	return &Node[K, V]{Key: j.Key, Val: j.Val, count: max(j.Count, 1), more: j.More,
+		Left: fromJSON(j.Left), Right: fromJSON(j.Right)}
+}
+
+func (t *Tree[K, V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSON(t.root))
+}
+
+func (t *Tree[K, V]) UnmarshalJSON(data []byte) error {
+	var j *jsonNode[K, V]
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	return t.adopt(fromJSON(j))
+}
+
+// Binary: "AVL1", node count (uvarint), then the nodes in pre-order, each as
+// one byte saying which children follow, the key and the value. A node with
+// duplicates also has the count and the number of more values (uvarints)
+// followed by those values. Keys and
+// values can be bools, ints, uints, floats, strings or []byte (or named
+// types of those), anything else has to go through JSON.
+
+var binaryMagic = []byte("AVL1")
+
+const (
+	hasLeft = 1 << iota
+	hasRight
+	hasDups
+)
+
+func (t *Tree[K, V]) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(binaryMagic)
+	nodes := 0
+	for it := NewPreOrderIter(t.root); it.Next(); {
+		nodes++
+	}
+	buf.Write(binary.AppendUvarint(nil, uint64(nodes)))
+	for it := NewPreOrderIter(t.root); it.Next(); {
+		n := it.Value()
+		var flags byte
+		if n.Left != nil {
+			flags |= hasLeft
+		}
+		if n.Right != nil {
+			flags |= hasRight
+		}
+		if n.count > 1 {
+			flags |= hasDups
+		}
+		buf.WriteByte(flags)
+		if err := writeValue(&buf, reflect.ValueOf(&n.Key).Elem()); err != nil {
+			return nil, err
+		}
+		if err := writeValue(&buf, reflect.ValueOf(&n.Val).Elem()); err != nil {
+			return nil, err
+		}
+		if flags&hasDups == 0 {
+			continue
+		}
+		buf.Write(binary.AppendUvarint(nil, uint64(n.count)))
+		buf.Write(binary.AppendUvarint(nil, uint64(len(n.more))))
+		for i := range n.more {
+			if err := writeValue(&buf, reflect.ValueOf(&n.more[i]).Elem()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (t *Tree[K, V]) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	magic := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, binaryMagic) {
+		return fmt.Errorf("%w: bad header", ErrBadTree)
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadTree, err)
+	}
+
+	// read the nodes back in the same pre-order they were written. A tree
+	// deeper than an AVL tree of count nodes can be is turned down right
+	// away, before it gets to use up the stack.
+	limit := maxHeight(count)
+	var read func(depth int) (*Node[K, V], error)
+	read = func(depth int) (*Node[K, V], error) {
+		if count == 0 {
+			return nil, fmt.Errorf("%w: more nodes than the count says", ErrBadTree)
+		}
+		if depth > limit {
+			return nil, fmt.Errorf("%w: deeper than %d, too deep for an AVL tree", ErrBadTree, limit)
+		}
+		count--
+		flags, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadTree, err)
+		}
+		n := &Node[K, V]{count: 1}
+		if err := readValue(r, reflect.ValueOf(&n.Key).Elem()); err != nil {
+			return nil, err
+		}
+		if err := readValue(r, reflect.ValueOf(&n.Val).Elem()); err != nil {
+			return nil, err
+		}
+		if flags&hasDups != 0 {
+			if err := readDups(r, n); err != nil {
+				return nil, err
+			}
+		}
+		if flags&hasLeft != 0 {
+			if n.Left, err = read(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		if flags&hasRight != 0 {
+			if n.Right, err = read(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return n, nil
+	}
+
+	var root *Node[K, V]
+	if count > 0 {
+		if root, err = read(1); err != nil {
+			return err
+		}
+	}
+	if count != 0 || r.Len() != 0 {
+		return fmt.Errorf("%w: trailing data", ErrBadTree)
+	}
+	return t.adopt(root)
+}
+
+func readDups[K, V any](r *bytes.Reader, n *Node[K, V]) error {
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadTree, err)
+	}
+	more, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadTree, err)
+	}
+	if count < 2 || count > math.MaxInt32 || more > count-1 || more > uint64(r.Len()) {
+		return fmt.Errorf("%w: bad count at key %v", ErrBadTree, n.Key)
+	}
+	n.count = int(count)
+	n.more = make([]V, more)
+	for i := range n.more {
+		if err := readValue(r, reflect.ValueOf(&n.more[i]).Elem()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeValue(buf *bytes.Buffer, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.Write(binary.AppendVarint(nil, v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		buf.Write(binary.AppendUvarint(nil, v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		buf.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(v.Float())))
+	case reflect.String:
+		buf.Write(binary.AppendUvarint(nil, uint64(v.Len())))
+		buf.WriteString(v.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("binary encoding of %s not supported", v.Type())
+		}
+		buf.Write(binary.AppendUvarint(nil, uint64(v.Len())))
+		buf.Write(v.Bytes())
+	default:
+		return fmt.Errorf("binary encoding of %s not supported", v.Type())
+	}
+	return nil
+}
+
+func readValue(r *bytes.Reader, v reflect.Value) error {
+	bad := func(err error) error { return fmt.Errorf("%w: %v", ErrBadTree, err) }
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := r.ReadByte()
+		if err != nil {
+			return bad(err)
+		}
+		v.SetBool(b != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := binary.ReadVarint(r)
+		if err != nil {
+			return bad(err)
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x, err := binary.ReadUvarint(r)
+		if err != nil {
+			return bad(err)
+		}
+		v.SetUint(x)
+	case reflect.Float32, reflect.Float64:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return bad(err)
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b[:])))
+	case reflect.String, reflect.Slice:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("binary decoding of %s not supported", v.Type())
+		}
+		l, err := binary.ReadUvarint(r)
+		if err != nil {
+			return bad(err)
+		}
+		if l > uint64(r.Len()) {
+			return bad(io.ErrUnexpectedEOF)
+		}
+		b := make([]byte, l)
+		io.ReadFull(r, b)
+		if v.Kind() == reflect.String {
+			v.SetString(string(b))
+		} else {
+			v.SetBytes(b)
+		}
+	default:
+		return fmt.Errorf("binary decoding of %s not supported", v.Type())
+	}
+	return nil
+}
+
+// WriteDOT draws the tree for graphviz (`dot -Tsvg`), every node showing it's
+// key, height and subtree size. Missing children are drawn as small points
+// so that a lone child still shows up on the correct side.
+func (t *Tree[K, V]) WriteDOT(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("digraph tree {\n\tnode [shape=circle];\n")
+	ids := map[*Node[K, V]]int{}
+	id := func(n *Node[K, V]) int {
+		if _, ok := ids[n]; !ok {
+			ids[n] = len(ids)
+		}
+		return ids[n]
+	}
+	nils := 0
+	for it := NewPreOrderIter(t.root); it.Next(); {
+		n := it.Value()
+		// quoted, so that a key with " or \ in it can't break out of the label
+		label := strconv.Quote(fmt.Sprintf("%v\nh=%d s=%d", n.Key, n.height, n.size))
+		fmt.Fprintf(&buf, "\tn%d [label=%s];\n", id(n), label)
+		if n.Left == nil && n.Right == nil {
+			continue
+		}
+		for _, c := range []*Node[K, V]{n.Left, n.Right} {
+			if c == nil {
+				fmt.Fprintf(&buf, "\tnil%d [shape=point];\n\tn%d -> nil%d;\n", nils, id(n), nils)
+				nils++
+			} else {
+				fmt.Fprintf(&buf, "\tn%d -> n%d;\n", id(n), id(c))
+			}
+		}
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
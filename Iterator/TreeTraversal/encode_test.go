@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMaxHeight(t *testing.T) {
+	for n, want := range map[uint64]int{0: 0, 1: 1, 2: 2, 3: 2, 4: 3, 6: 3, 7: 4, 12: 5, 1000: 14} {
+		if got := maxHeight(n); got != want {
+			t.Errorf("maxHeight(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	for _, p := range []DupPolicy{DupReplace, DupCount, DupList} {
+		tr := NewOrderedTree[int, string]().WithPolicy(p)
+		for i := range 500 {
+			tr.Insert(i%200, "v")
+		}
+		data, err := tr.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		back := NewOrderedTree[int, string]().WithPolicy(p)
+		if err := back.UnmarshalBinary(data); err != nil {
+			t.Fatalf("policy %d: %v", p, err)
+		}
+		if back.Len() != tr.Len() {
+			t.Fatalf("policy %d: len %d, want %d", p, back.Len(), tr.Len())
+		}
+	}
+}
+
+// a left-only chain of n nodes, much deeper than any AVL tree of n nodes.
+func leftChain(n int) []byte {
+	buf := bytes.NewBuffer([]byte("AVL1"))
+	buf.Write(binary.AppendUvarint(nil, uint64(n)))
+	for i := n; i > 0; i-- {
+		flags := byte(hasLeft)
+		if i == 1 {
+			flags = 0
+		}
+		buf.WriteByte(flags)
+		buf.Write(binary.AppendVarint(nil, int64(i)))
+		buf.Write(binary.AppendVarint(nil, 0))
+	}
+	return buf.Bytes()
+}
+
+func TestBinaryTooDeep(t *testing.T) {
+	tr := NewOrderedTree[int, int]()
+	if err := tr.UnmarshalBinary(leftChain(1 << 20)); !errors.Is(err, ErrBadTree) || !strings.Contains(err.Error(), "too deep") {
+		t.Fatalf("got %v, want ErrBadTree", err)
+	}
+	if tr.Root() != nil {
+		t.Fatal("tree changed by a failed load")
+	}
+}
+
+func TestLoadChecksPolicy(t *testing.T) {
+	multi := NewOrderedTree[int, string]().WithPolicy(DupList)
+	multi.Insert(1, "a")
+	multi.Insert(1, "b")
+	counted := NewOrderedTree[int, string]().WithPolicy(DupCount)
+	counted.Insert(1, "a")
+	counted.Insert(1, "a")
+
+	for _, src := range []*Tree[int, string]{multi, counted} {
+		bin, _ := src.MarshalBinary()
+		js, _ := json.Marshal(src)
+		for _, p := range []DupPolicy{DupReplace, DupReject} {
+			if err := NewOrderedTree[int, string]().WithPolicy(p).UnmarshalBinary(bin); !errors.Is(err, ErrBadTree) {
+				t.Errorf("binary into policy %d: got %v", p, err)
+			}
+			if err := json.Unmarshal(js, NewOrderedTree[int, string]().WithPolicy(p)); !errors.Is(err, ErrBadTree) {
+				t.Errorf("json into policy %d: got %v", p, err)
+			}
+		}
+	}
+	// a list has to have every value, a count none
+	bin, _ := multi.MarshalBinary()
+	if err := NewOrderedTree[int, string]().WithPolicy(DupCount).UnmarshalBinary(bin); !errors.Is(err, ErrBadTree) {
+		t.Errorf("list into DupCount: got %v", err)
+	}
+	bin, _ = counted.MarshalBinary()
+	if err := NewOrderedTree[int, string]().WithPolicy(DupList).UnmarshalBinary(bin); !errors.Is(err, ErrBadTree) {
+		t.Errorf("count into DupList: got %v", err)
+	}
+	if err := NewOrderedTree[int, string]().WithPolicy(DupCount).UnmarshalBinary(bin); err != nil {
+		t.Errorf("count into DupCount: %v", err)
+	}
+}
+
+// a key with quotes and backslashes in it stays inside it's label.
+func TestWriteDOTEscapes(t *testing.T) {
+	tr := NewOrderedTree[string, int]()
+	tr.Insert(`say "hi"`, 1)
+	tr.Insert(`C:\dir`, 2)
+	var buf bytes.Buffer
+	if err := tr.WriteDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+	for _, want := range []string{`label="say \"hi\"\nh=`, `label="C:\\dir\nh=`} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("no %s in\n%s", want, dot)
+		}
+	}
+	// every label is one well formed quoted string
+	for _, line := range strings.Split(dot, "\n") {
+		_, rest, ok := strings.Cut(line, "[label=")
+		if !ok {
+			continue
+		}
+		if _, err := strconv.Unquote(strings.TrimSuffix(rest, "];")); err != nil {
+			t.Errorf("bad label in %q: %v", line, err)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+type NodeIter[K, V any] struct {
+	node  *Node[K, V]
+	root  *Node[K, V]
+	flag  bool        // false means we are before start (nothing yielded yet)
+	start *Node[K, V] // first node of the walk, Reset comes back here
+	end   *Node[K, V] // stop (exclusive) once we get here, nil means run to the end
+	rep   int         // which copy of node we are at, for duplicate keys
+	guard
+}
+
+func NewNodeIter[K, V any](root *Node[K, V]) *NodeIter[K, V] {
+	/*
+	 * Since inorder traversal start from leftmost node, so make sure
+	 * you are always at the leftmost node of any given subtree
+	 */
+	start := leftmost(root)
+	return &NodeIter[K, V]{node: start, root: root, start: start}
+}
+
+// Reset rewinds to before the first node, so the next Next() gives it again.
+func (it *NodeIter[K, V]) Reset() {
+	it.node = it.start
+	it.flag = false
+	it.rep = 0
+}
+
+func (it *NodeIter[K, V]) Next() bool {
+	if !it.ok() {
+		it.node = nil
+		return false
+	}
+	if !it.flag {
+		it.flag = true
+		return it.node != nil
+	}
+	if it.node == nil {
+		return false // already ran off the end
+	}
+	if it.rep+1 < it.node.count {
+		it.rep++ // same key again
+		return true
+	}
+	it.rep = 0
+	it.node = successor(it.node, it.root)
+	if it.node == it.end {
+		it.node = nil
+	}
+	return it.node != nil
+}
+
+// Prev is Next in the other direction. Walking back past the first node puts
+// the iterator before the start again, and calling it after Next() has run
+// off the end lands on the last node, so a cursor can go back and forth.
+func (it *NodeIter[K, V]) Prev() bool {
+	switch {
+	case !it.ok():
+		it.node = nil
+		return false
+	case !it.flag:
+		return false
+	case it.node == nil:
+		return it.Last()
+	case it.rep > 0:
+		it.rep--
+		return true
+	case it.node == it.start:
+		it.Reset()
+		return false
+	}
+	it.node = predecessor(it.node, it.root)
+	it.rep = it.node.count - 1
+	return true
+}
+
+// First and Last jump straight to either end of the walk.
+func (it *NodeIter[K, V]) First() bool {
+	if !it.ok() {
+		it.node = nil
+		return false
+	}
+	it.flag = true
+	it.node = it.start
+	it.rep = 0
+	return it.node != nil
+}
+
+func (it *NodeIter[K, V]) Last() bool {
+	if !it.ok() {
+		it.node = nil
+		return false
+	}
+	it.flag = true
+	switch {
+	case it.start == nil:
+		it.node = nil
+	case it.end != nil:
+		it.node = predecessor(it.end, it.root)
+	default:
+		it.node = rightmost(it.root)
+	}
+	if it.node == nil {
+		return false
+	}
+	it.rep = it.node.count - 1
+	return true
+}
+
+func (it *NodeIter[K, V]) Value() *Node[K, V] {
+	return it.node
+}
+
+// Pair is the key and value at the iterator. Unlike Value().Val it gives
+// the right value for each copy of a key with the DupList policy.
+func (it *NodeIter[K, V]) Pair() (K, V) {
+	return it.node.Key, it.node.valueAt(it.rep)
+}
+
+// NodeIter above only does inorder. Below are the other orders, all of them
+// with the same Next()/Value()/Reset() contract, so whoever consumes them
+// does not care which walk it is getting.
+//
+// Pre/post/level order are about the shape of the tree, so they give every
+// node once, even when it's key has duplicates (see dup.go).
+//
+// Except for level order (which needs a queue) they all walk using the Parent
+// links, so they take O(1) extra space. The root given is treated as the top,
+// i.e. they never climb above it even if it has a Parent.
+
+func leftmost[K, V any](n *Node[K, V]) *Node[K, V] {
+	for n != nil && n.Left != nil {
+		n = n.Left
+	}
+	return n
+}
+
+func rightmost[K, V any](n *Node[K, V]) *Node[K, V] {
+	for n != nil && n.Right != nil {
+		n = n.Right
+	}
+	return n
+}
+
+// successor of n in inorder, nil once we fall off the right of root.
+func successor[K, V any](n, root *Node[K, V]) *Node[K, V] {
+	if n.Right != nil {
+		return leftmost(n.Right)
+	}
+	for n != root && n == n.Parent.Right {
+		n = n.Parent
+	}
+	if n == root {
+		return nil
+	}
+	return n.Parent
+}
+
+// predecessor of n in inorder, nil once we fall off the left of root.
+func predecessor[K, V any](n, root *Node[K, V]) *Node[K, V] {
+	if n.Left != nil {
+		return rightmost(n.Left)
+	}
+	for n != root && n == n.Parent.Left {
+		n = n.Parent
+	}
+	if n == root {
+		return nil
+	}
+	return n.Parent
+}
+
+// reverse inorder: rightmost node first and then predecessors.
+type ReverseNodeIter[K, V any] struct {
+	node  *Node[K, V]
+	root  *Node[K, V]
+	flag  bool
+	start *Node[K, V] // where Reset goes back to
+	rep   int         // copies of node still to go, for duplicate keys
+	guard
+}
+
+func NewReverseNodeIter[K, V any](root *Node[K, V]) *ReverseNodeIter[K, V] {
+	it := &ReverseNodeIter[K, V]{root: root, start: rightmost(root)}
+	it.Reset()
+	return it
+}
+
+func (it *ReverseNodeIter[K, V]) Reset() {
+	it.node = it.start
+	it.flag = false
+	if it.node != nil {
+		it.rep = it.node.count - 1 // copies are walked backwards too
+	}
+}
+
+func (it *ReverseNodeIter[K, V]) Next() bool {
+	if it.node == nil || !it.ok() {
+		it.node = nil
+		return false
+	}
+	if !it.flag {
+		it.flag = true
+		return true
+	}
+	if it.rep > 0 {
+		it.rep--
+		return true
+	}
+	it.node = predecessor(it.node, it.root)
+	if it.node == nil {
+		return false
+	}
+	it.rep = it.node.count - 1
+	return true
+}
+
+func (it *ReverseNodeIter[K, V]) Value() *Node[K, V] {
+	return it.node
+}
+
+func (it *ReverseNodeIter[K, V]) Pair() (K, V) {
+	return it.node.Key, it.node.valueAt(it.rep)
+}
+
+// preorder: node, then left subtree, then right subtree.
+type PreOrderIter[K, V any] struct {
+	node *Node[K, V]
+	root *Node[K, V]
+	flag bool
+	guard
+}
+
+func NewPreOrderIter[K, V any](root *Node[K, V]) *PreOrderIter[K, V] {
+	return &PreOrderIter[K, V]{node: root, root: root}
+}
+
+func (it *PreOrderIter[K, V]) Reset() {
+	it.node = it.root
+	it.flag = false
+}
+
+func (it *PreOrderIter[K, V]) Next() bool {
+	if it.node == nil || !it.ok() {
+		it.node = nil
+		return false
+	}
+	if !it.flag {
+		it.flag = true
+		return true
+	}
+	n := it.node
+	if n.Left != nil {
+		it.node = n.Left
+		return true
+	}
+	if n.Right != nil {
+		it.node = n.Right
+		return true
+	}
+	/*
+	 * we are at a leaf, so climb up until we come out of a left subtree
+	 * whose parent still has a right subtree to visit.
+	 */
+	for n != it.root {
+		p := n.Parent
+		if n == p.Left && p.Right != nil {
+			it.node = p.Right
+			return true
+		}
+		n = p
+	}
+	it.node = nil
+	return false
+}
+
+func (it *PreOrderIter[K, V]) Value() *Node[K, V] {
+	return it.node
+}
+
+func (it *PreOrderIter[K, V]) Pair() (K, V) {
+	return it.node.Key, it.node.Val
+}
+
+// postorder: left subtree, then right subtree, then node.
+type PostOrderIter[K, V any] struct {
+	node *Node[K, V]
+	root *Node[K, V]
+	flag bool
+	guard
+}
+
+// first node in postorder is the leftmost leaf, i.e. keep going down
+// preferring left but taking right when there is no left.
+func firstPostOrder[K, V any](n *Node[K, V]) *Node[K, V] {
+	for n != nil {
+		switch {
+		case n.Left != nil:
+			n = n.Left
+		case n.Right != nil:
+			n = n.Right
+		default:
+			return n
+		}
+	}
+	return nil
+}
+
+func NewPostOrderIter[K, V any](root *Node[K, V]) *PostOrderIter[K, V] {
+	return &PostOrderIter[K, V]{node: firstPostOrder(root), root: root}
+}
+
+func (it *PostOrderIter[K, V]) Reset() {
+	it.node = firstPostOrder(it.root)
+	it.flag = false
+}
+
+func (it *PostOrderIter[K, V]) Next() bool {
+	if it.node == nil || !it.ok() {
+		it.node = nil
+		return false
+	}
+	if !it.flag {
+		it.flag = true
+		return true
+	}
+	n := it.node
+	if n == it.root {
+		it.node = nil
+		return false
+	}
+	// coming up from the left we still have the right sibling to do first.
+	p := n.Parent
+	if n == p.Left && p.Right != nil {
+		it.node = firstPostOrder(p.Right)
+	} else {
+		it.node = p
+	}
+	return true
+}
+
+func (it *PostOrderIter[K, V]) Value() *Node[K, V] {
+	return it.node
+}
+
+func (it *PostOrderIter[K, V]) Pair() (K, V) {
+	return it.node.Key, it.node.Val
+}
+
+// level order (breadth first). This is the one which can't be done with
+// Parent links alone, so it keeps a queue of the next level.
+type LevelOrderIter[K, V any] struct {
+	queue []*Node[K, V]
+	node  *Node[K, V]
+	root  *Node[K, V]
+	guard
+}
+
+func NewLevelOrderIter[K, V any](root *Node[K, V]) *LevelOrderIter[K, V] {
+	it := &LevelOrderIter[K, V]{root: root}
+	it.Reset()
+	return it
+}
+
+func (it *LevelOrderIter[K, V]) Reset() {
+	it.node = nil
+	it.queue = it.queue[:0]
+	if it.root != nil {
+		it.queue = append(it.queue, it.root)
+	}
+}
+
+func (it *LevelOrderIter[K, V]) Next() bool {
+	if len(it.queue) == 0 || !it.ok() {
+		it.node = nil
+		return false
+	}
+	it.node = it.queue[0]
+	it.queue = it.queue[1:]
+	if it.node.Left != nil {
+		it.queue = append(it.queue, it.node.Left)
+	}
+	if it.node.Right != nil {
+		it.queue = append(it.queue, it.node.Right)
+	}
+	return true
+}
+
+func (it *LevelOrderIter[K, V]) Value() *Node[K, V] {
+	return it.node
+}
+
+func (it *LevelOrderIter[K, V]) Pair() (K, V) {
+	return it.node.Key, it.node.Val
+}
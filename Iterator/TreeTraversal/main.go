@@ -1,110 +1,210 @@
 package main
 
-import "fmt"
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/riteshharjani/design-pattens-go/Iterator/bptree"
+	"github.com/riteshharjani/design-pattens-go/Iterator/lazy"
+)
 
 // e.g. using binary Tree.
-//
-type Node struct {
-	Val    int
-	Left   *Node
-	Right  *Node
-	Parent *Node
-}
+// The Node and the Tree (AVL balanced) are in tree.go, the iterators which
+// walk over it are in iter.go.
 
-type NodeIter struct {
-	node *Node
-	root *Node
-	flag bool
+func (n *Node[K, V]) dfs() {
+	if n == nil {
+		return
+	}
+	n.Left.dfs()
+	fmt.Println(n.Key)
+	n.Right.dfs()
 }
 
-func NewNodeIter(root *Node) *NodeIter {
-	it := &NodeIter{root, root, false}
-	/*
-	 * Since inorder traversal start from leftmost node, so make sure
-	 * you are always at the leftmost node of any given subtree
-	 */
-	for it.node.Left != nil {
-		it.node = it.node.Left
+func keys[K, V any](it nodeIterator[K, V]) []K {
+	var ks []K
+	for it.Next() {
+		ks = append(ks, it.Value().Key)
 	}
-	return it
+	return ks
 }
 
-func (it *NodeIter) Reset() {
-	it.node = it.root
-	it.flag = false
-}
+func main() {
+	fmt.Println("vim-go")
+	tree := NewOrderedTree[int, string]()
+	tree.Insert(5, "five")
+	for i := 1; i < 10; i++ {
+		tree.Insert(i, fmt.Sprint("#", i))
+	}
+
+	tree.Root().dfs()
 
-func (it *NodeIter) Next() bool {
-	if it.node == nil {
-		return false
+	for it := NewNodeIter(tree.Root()); it.Next(); {
+		fmt.Println("====>", it.node.Key, it.node.Val)
 	}
-	if !it.flag {
-		it.flag = true
-		return true
+
+	// with the plain BST this used to be a height of 9 (a linked list)
+	fmt.Println("len:", tree.Len(), "height:", tree.Height())
+
+	fmt.Println("reverse:", keys(NewReverseNodeIter(tree.Root())))
+	fmt.Println("preorder:", keys(NewPreOrderIter(tree.Root())))
+	fmt.Println("postorder:", keys(NewPostOrderIter(tree.Root())))
+	fmt.Println("levelorder:", keys(NewLevelOrderIter(tree.Root())))
+
+	// ordered index queries, these start at the right node straight away.
+	fmt.Println("range [3, 6]:", keys(tree.Range(3, 6)))
+	fmt.Println("seek >= 7:", keys(tree.SeekGE(7)))
+	fmt.Println("seek < 4:", keys(tree.SeekLT(4)))
+
+	// a cursor can go both ways, e.g. next page and then back again.
+	cur := tree.Range(2, 8)
+	cur.Next()
+	cur.Next()
+	cur.Next()
+	fmt.Print("cursor at ", cur.Value().Key, ", back:")
+	for cur.Prev() {
+		fmt.Print(" ", cur.Value().Key)
 	}
-	if it.node.Right != nil {
-		it.node = it.node.Right
-		for it.node.Left != nil {
-			it.node = it.node.Left
-		}
-		return true
-	} else {
-		p := it.node.Parent
-		for p != nil && it.node == p.Right {
-			it.node = p
-			p = p.Parent
+	cur.Last()
+	fmt.Println(", last:", cur.Value().Key)
+	cur.Reset()
+	fmt.Println("after reset:", keys(cur))
+
+	// the same walks work with a plain for range, and break just stops it.
+	for k, v := range tree.Backward() {
+		if k < 8 {
+			break
 		}
-		it.node = p
-		return it.node != nil
+		fmt.Println("backward:", k, v)
 	}
-}
+	fmt.Println("keys:", slices.Collect(tree.Keys()))
 
-func (it *NodeIter) Value() *Node {
-	return it.node
-}
+	// the same inserts into a multiset keep both the 5s (like the plain BST
+	// did), while a set refuses the second one.
+	ms := NewOrderedTree[int, string]().WithPolicy(DupCount)
+	ms.Insert(5, "five")
+	for i := 1; i < 10; i++ {
+		ms.Insert(i, fmt.Sprint("#", i))
+	}
+	fmt.Println("multiset:", slices.Collect(ms.Keys()), "len:", ms.Len())
+	set := NewOrderedTree[int, string]().WithPolicy(DupReject)
+	set.Insert(5, "five")
+	fmt.Println("set:", set.Insert(5, "#5"))
 
-func NewNode(val int) *Node {
-	return &Node{val, nil, nil, nil}
-}
+	// order statistics, e.g. for a leaderboard: where is 7, who is 3rd,
+	// how many in [2, 6].
+	fmt.Println("rank of 7:", tree.Rank(7), "3rd:", tree.Select(2).Key,
+		"in [2, 6]:", tree.CountRange(2, 6), "median:", tree.Median().Key)
 
-func (n *Node) AddNode(val int) *Node {
-	if n == nil {
-		return NewNode(val)
+	// NodeIter plugs into the lazy combinators too, e.g. sum of squares of
+	// the even keys.
+	it := tree.Iter()
+	evens := lazy.Filter(lazy.FromNext(it.Next, it.Value), func(n *Node[int, string]) bool { return n.Key%2 == 0 })
+	squares := lazy.Map(evens, func(n *Node[int, string]) int { return n.Key * n.Key })
+	fmt.Println("sum of even squares:", lazy.Reduce(squares, 0, func(acc, x int) int { return acc + x }))
+
+	// sorted shards merge into one sorted stream, w/o concatenating and
+	// sorting, here two trees and a slice with some keys in common.
+	odd, even := NewOrderedTree[int, string](), NewOrderedTree[int, string]()
+	for i := 1; i < 10; i++ {
+		if i%2 == 0 {
+			even.Insert(i, "")
+		} else {
+			odd.Insert(i, "")
+		}
 	}
-	var node *Node = (*Node)(nil)
-	if val < n.Val {
-		node = n.Left.AddNode(val)
-		n.Left = node
-		node.Parent = n
-	} else {
-		node = n.Right.AddNode(val)
-		n.Right = node
-		node.Parent = n
+	keysOf := func(t *Tree[int, string]) lazy.Iterator[int] {
+		it := t.Iter()
+		return lazy.Map(lazy.FromNext(it.Next, it.Value), func(n *Node[int, string]) int { return n.Key })
 	}
-	return n
-}
+	merged := lazy.MergeDistinct(cmp.Compare[int], keysOf(odd), keysOf(even), lazy.FromSlice([]int{0, 4, 10}))
+	fmt.Println("merged:", lazy.Collect(merged))
 
-func (n *Node) dfs() {
-	if n == nil {
-		return
+	// many goroutines at once go through a SyncTree; the readers take no
+	// lock at all, each walk sees the tree as it was when it started.
+	st := NewSyncTree(NewOrderedTree[int, string]())
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				st.Insert(w*100+i, "")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				prev := -1
+				for k := range st.InOrder() {
+					if k <= prev {
+						panic("SyncIter out of order")
+					}
+					prev = k
+				}
+			}
+		}()
 	}
-	n.Left.dfs()
-	fmt.Println(n.Val)
-	n.Right.dfs()
-}
+	wg.Wait()
+	fmt.Println("concurrent inserts:", st.Len())
 
-func main() {
-	fmt.Println("vim-go")
-	root := NewNode(5)
-	for i := 1; i < 10; i++ {
-		root.AddNode(i)
+	// persist and load back, the loaded tree can be iterated straight away.
+	data, _ := json.Marshal(tree)
+	fromJSON := NewOrderedTree[int, string]()
+	if err := json.Unmarshal(data, fromJSON); err != nil {
+		panic(err)
+	}
+	bin, _ := tree.MarshalBinary()
+	fromBin := NewOrderedTree[int, string]()
+	if err := fromBin.UnmarshalBinary(bin); err != nil {
+		panic(err)
 	}
+	fmt.Println("json bytes:", len(data), "binary bytes:", len(bin))
+	fmt.Println("from json:", keys(fromJSON.Iter()), "from binary:", keys(fromBin.Iter()))
 
-	root.dfs()
+	small := NewOrderedTree[int, string]()
+	small.Insert(1, "a")
+	small.Insert(2, "b")
+	small.WriteDOT(os.Stdout)
 
-	for it := NewNodeIter(root); it.Next(); {
-		fmt.Println("====>", it.node.Val)
+	// changing the tree under a live iterator stops it with an error ...
+	it = tree.Iter()
+	it.Next()
+	tree.Insert(10, "#10")
+	fmt.Println("next after insert:", it.Next(), it.Err())
+
+	// ... while a snapshot keeps seeing the tree as it was.
+	snap := tree.Snapshot()
+	tree.Delete(4)
+	tree.Delete(5)
+	fmt.Println("snapshot:", keys(snap.Iter()))
+	fmt.Println("live:", keys(tree.Iter()))
+
+	v, ok := tree.Get(5)
+	fmt.Printf("get 5: %q %v, len: %d\n", v, ok, tree.Len())
+
+	// the same index can live in a file instead of memory; it's iterator has
+	// the same Next()/Value() contract so the lazy pipeline above does not change.
+	dir, _ := os.MkdirTemp("", "bptree")
+	defer os.RemoveAll(dir)
+	db, err := bptree.Open(filepath.Join(dir, "index.db"), nil)
+	if err != nil {
+		panic(err)
 	}
+	for k, v := range tree.InOrder() {
+		db.Put(int64(k), []byte(v))
+	}
+	db.Close()
+	db, _ = bptree.Open(filepath.Join(dir, "index.db"), nil)
+	defer db.Close()
+	rng := db.Range(3, 8)
+	onDisk := lazy.Map(lazy.FromNext(rng.Next, rng.Value), func(e bptree.Entry) string { return string(e.Val) })
+	fmt.Println("on disk [3, 8]:", lazy.Collect(onDisk), "len:", db.Len())
 	// o/p of above:-
 	// vim-go
 	// 1
@@ -112,19 +212,51 @@ func main() {
 	// 3
 	// 4
 	// 5
-	// 5
 	// 6
 	// 7
 	// 8
 	// 9
-	// ====> 1
-	// ====> 2
-	// ====> 3
-	// ====> 4
-	// ====> 5
-	// ====> 5
-	// ====> 6
-	// ====> 7
-	// ====> 8
-	// ====> 9
+	// ====> 1 #1
+	// ====> 2 #2
+	// ====> 3 #3
+	// ====> 4 #4
+	// ====> 5 #5
+	// ====> 6 #6
+	// ====> 7 #7
+	// ====> 8 #8
+	// ====> 9 #9
+	// len: 9 height: 4
+	// reverse: [9 8 7 6 5 4 3 2 1]
+	// preorder: [4 2 1 3 6 5 8 7 9]
+	// postorder: [1 3 2 5 7 9 8 6 4]
+	// levelorder: [4 2 6 1 3 5 8 7 9]
+	// range [3, 6]: [3 4 5 6]
+	// seek >= 7: [7 8 9]
+	// seek < 4: [3 2 1]
+	// cursor at 4, back: 3 2, last: 8
+	// after reset: [2 3 4 5 6 7 8]
+	// backward: 9 #9
+	// backward: 8 #8
+	// keys: [1 2 3 4 5 6 7 8 9]
+	// multiset: [1 2 3 4 5 5 6 7 8 9] len: 10
+	// set: duplicate key: 5
+	// rank of 7: 6 3rd: 3 in [2, 6]: 5 median: 5
+	// sum of even squares: 120
+	// merged: [0 1 2 3 4 5 6 7 8 9 10]
+	// concurrent inserts: 400
+	// json bytes: 248 binary bytes: 50
+	// from json: [1 2 3 4 5 6 7 8 9] from binary: [1 2 3 4 5 6 7 8 9]
+	// digraph tree {
+	// 	node [shape=circle];
+	// 	n0 [label="1\nh=2 s=2"];
+	// 	nil0 [shape=point];
+	// 	n0 -> nil0;
+	// 	n0 -> n1;
+	// 	n1 [label="2\nh=1 s=1"];
+	// }
+	// next after insert: false tree modified during iteration
+	// snapshot: [1 2 3 4 5 6 7 8 9 10]
+	// live: [1 2 3 6 7 8 9 10]
+	// get 5: "" false, len: 8
+	// on disk [3, 8]: [#3 #6 #7 #8] len: 8
 }
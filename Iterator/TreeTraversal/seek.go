@@ -0,0 +1,73 @@
+package main
+
+// When the tree is used as an ordered index we mostly want "all keys between
+// X and Y". Starting a NodeIter at the leftmost node and skipping would be
+// O(n), so instead we go down from the root once (O(log n)) to find the first
+// node and from there it is the usual successor walk of NodeIter.
+
+// ceiling is the smallest node with key >= key.
+func (t *Tree[K, V]) ceiling(key K) *Node[K, V] {
+	var found *Node[K, V]
+	for n := t.root; n != nil; {
+		if t.cmp(n.Key, key) >= 0 {
+			found = n
+			n = n.Left
+		} else {
+			n = n.Right
+		}
+	}
+	return found
+}
+
+// higher is the smallest node with key > key.
+func (t *Tree[K, V]) higher(key K) *Node[K, V] {
+	var found *Node[K, V]
+	for n := t.root; n != nil; {
+		if t.cmp(n.Key, key) > 0 {
+			found = n
+			n = n.Left
+		} else {
+			n = n.Right
+		}
+	}
+	return found
+}
+
+// lower is the largest node with key < key.
+func (t *Tree[K, V]) lower(key K) *Node[K, V] {
+	var found *Node[K, V]
+	for n := t.root; n != nil; {
+		if t.cmp(n.Key, key) < 0 {
+			found = n
+			n = n.Right
+		} else {
+			n = n.Left
+		}
+	}
+	return found
+}
+
+// SeekGE gives an inorder iterator whose first Next() lands on the smallest
+// key >= key.
+func (t *Tree[K, V]) SeekGE(key K) *NodeIter[K, V] {
+	start := t.ceiling(key)
+	return &NodeIter[K, V]{node: start, root: t.root, start: start, guard: t.guard()}
+}
+
+// SeekLT gives a reverse iterator whose first Next() lands on the largest
+// key < key, i.e. it walks downwards from just below key.
+func (t *Tree[K, V]) SeekLT(key K) *ReverseNodeIter[K, V] {
+	it := &ReverseNodeIter[K, V]{root: t.root, start: t.lower(key), guard: t.guard()}
+	it.Reset()
+	return it
+}
+
+// Range walks the keys in [lo, hi] in order. The upper bound is turned into
+// the first node past hi, so the iterator just stops when it reaches it.
+func (t *Tree[K, V]) Range(lo, hi K) *NodeIter[K, V] {
+	start, end := t.ceiling(lo), t.higher(hi)
+	if start == end || t.cmp(lo, hi) > 0 {
+		start = nil // empty range
+	}
+	return &NodeIter[K, V]{node: start, root: t.root, start: start, end: end, guard: t.guard()}
+}
@@ -0,0 +1,88 @@
+package main
+
+import "iter"
+
+// Since go 1.23 a function can be ranged over directly, so instead of
+//
+//	for it := tree.Iter(); it.Next(); { ... it.Value() ... }
+//
+// we can just say
+//
+//	for k, v := range tree.InOrder() { ... }
+//
+// and a break in the loop body simply stops the walk, nothing is left behind.
+
+// every walk has the same contract, so one adapter can wrap any of them.
+type nodeIterator[K, V any] interface {
+	Next() bool
+	Value() *Node[K, V]
+	Pair() (K, V)
+	Err() error
+}
+
+// Nodes turns any of the node iterators into a range-able sequence.
+// If the tree gets modified during the loop the walk can't carry on, and as
+// a range loop has no way to hand back an error, it panics with
+// ErrConcurrentModification (iterate a Snapshot() to avoid that).
+func Nodes[K, V any](it nodeIterator[K, V]) iter.Seq[*Node[K, V]] {
+	return func(yield func(*Node[K, V]) bool) {
+		for it.Next() {
+			if !yield(it.Value()) {
+				return
+			}
+		}
+		if err := it.Err(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Pairs is Nodes but giving key, value.
+func Pairs[K, V any](it nodeIterator[K, V]) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for range Nodes(it) {
+			if !yield(it.Pair()) {
+				return
+			}
+		}
+	}
+}
+
+// Each call to the function returned starts a fresh walk, that is why these
+// create the iterator inside and not outside of the closure.
+
+func (t *Tree[K, V]) InOrder() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) { Pairs[K, V](t.Iter())(yield) }
+}
+
+func (t *Tree[K, V]) Backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) { Pairs[K, V](t.ReverseIter())(yield) }
+}
+
+func (t *Tree[K, V]) PreOrder() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) { Pairs[K, V](t.PreOrderIter())(yield) }
+}
+
+func (t *Tree[K, V]) PostOrder() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) { Pairs[K, V](t.PostOrderIter())(yield) }
+}
+
+func (t *Tree[K, V]) LevelOrder() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) { Pairs[K, V](t.LevelOrderIter())(yield) }
+}
+
+// Between is Range(lo, hi) as a sequence.
+func (t *Tree[K, V]) Between(lo, hi K) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) { Pairs[K, V](t.Range(lo, hi))(yield) }
+}
+
+// Keys is handy with slices.Collect.
+func (t *Tree[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for k := range t.InOrder() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"slices"
+)
+
+// Iterators walk the live nodes, so if the tree changes shape while one is
+// half way through, it would silently skip or repeat nodes (or walk a node
+// which was deleted). Two ways around it:
+//
+// 1. fail fast - the tree counts it's modifications and an iterator which was
+//    created from the tree remembers the count. The moment they differ the
+//    iterator stops and Err() tells why (like java's
+//    ConcurrentModificationException, but w/o the panic).
+// 2. snapshot - iterate a Snapshot() of the tree instead, which never changes.
+//
+// Note: iterators made with NewNodeIter(root) etc. only know about nodes and
+// not the tree, so they can't do the check. Use the Tree methods below.
+
+var ErrConcurrentModification = errors.New("tree modified during iteration")
+
+// guard is embedded in every iterator.
+type guard struct {
+	mods *uint64 // the tree's counter, nil if we don't know the tree
+	seen uint64
+	err  error
+}
+
+func (g *guard) ok() bool {
+	if g.err == nil && g.mods != nil && *g.mods != g.seen {
+		g.err = ErrConcurrentModification
+	}
+	return g.err == nil
+}
+
+// Err is nil unless the iteration was cut short by a change to the tree.
+func (g *guard) Err() error {
+	return g.err
+}
+
+func (t *Tree[K, V]) guard() guard {
+	return guard{mods: &t.mods, seen: t.mods}
+}
+
+func (t *Tree[K, V]) Iter() *NodeIter[K, V] {
+	it := NewNodeIter(t.root)
+	it.guard = t.guard()
+	return it
+}
+
+func (t *Tree[K, V]) ReverseIter() *ReverseNodeIter[K, V] {
+	it := NewReverseNodeIter(t.root)
+	it.guard = t.guard()
+	return it
+}
+
+func (t *Tree[K, V]) PreOrderIter() *PreOrderIter[K, V] {
+	it := NewPreOrderIter(t.root)
+	it.guard = t.guard()
+	return it
+}
+
+func (t *Tree[K, V]) PostOrderIter() *PostOrderIter[K, V] {
+	it := NewPostOrderIter(t.root)
+	it.guard = t.guard()
+	return it
+}
+
+func (t *Tree[K, V]) LevelOrderIter() *LevelOrderIter[K, V] {
+	it := NewLevelOrderIter(t.root)
+	it.guard = t.guard()
+	return it
+}
+
+// Snapshot gives a read-only view of the tree as it is right now. It is O(1):
+// both trees share the nodes, and whichever of them is written first takes a
+// private copy of the nodes before writing (copy-on-write).
+//
+// Ideally only the path from the root to the changed node would be copied
+// (a persistent tree), but with Parent links every child points at it's
+// parent, so copying a node means copying it's children too, i.e. the whole
+// tree. So the copy is O(n), paid once by the first writer after a snapshot,
+// and the snapshot never pays anything.
+//
+// Snapshot marks the tree as shared, so as far as locking goes it counts as
+// a write.
+func (t *Tree[K, V]) Snapshot() *Tree[K, V] {
+	t.shared = true
+	return &Tree[K, V]{root: t.root, cmp: t.cmp, policy: t.policy, shared: true}
+}
+
+func (t *Tree[K, V]) unshare() {
+	if !t.shared {
+		return
+	}
+	t.root = cloneNode(t.root, nil)
+	t.shared = false
+	t.mods++ // our own iterators are still on the old (now snapshot's) nodes
+}
+
+func cloneNode[K, V any](n, parent *Node[K, V]) *Node[K, V] {
+	if n == nil {
+		return nil
+	}
+	c := *n
+	c.more = slices.Clone(n.more)
+	c.Parent = parent
+	c.Left = cloneNode(n.Left, &c)
+	c.Right = cloneNode(n.Right, &c)
+	return &c
+}
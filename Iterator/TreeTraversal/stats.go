@@ -0,0 +1,73 @@
+package main
+
+// Order statistics. Every node knows the size of it's subtree, so the
+// position of a key can be worked out on the way down from the root instead
+// of counting with NodeIter from the leftmost node, i.e. O(log n) not O(n).
+//
+// With DupCount/DupList a key counts as many times as it is in the tree, the
+// same as the inorder iterators yield it.
+
+// Rank is the number of keys < key (so it is also the index key has, or
+// would have, in sorted order).
+func (t *Tree[K, V]) Rank(key K) int {
+	rank := 0
+	for n := t.root; n != nil; {
+		if t.cmp(key, n.Key) <= 0 {
+			n = n.Left
+		} else {
+			rank += size(n.Left) + n.count
+			n = n.Right
+		}
+	}
+	return rank
+}
+
+// countLE is the number of keys <= key.
+func (t *Tree[K, V]) countLE(key K) int {
+	count := 0
+	for n := t.root; n != nil; {
+		if t.cmp(key, n.Key) < 0 {
+			n = n.Left
+		} else {
+			count += size(n.Left) + n.count
+			n = n.Right
+		}
+	}
+	return count
+}
+
+// Select gives the node at index k (0 based) in sorted order, nil if k is
+// out of range. Select(Rank(key)) is the node of key, if present. A key with
+// duplicates covers as many indexes as it has copies.
+func (t *Tree[K, V]) Select(k int) *Node[K, V] {
+	if k < 0 || k >= t.Len() {
+		return nil
+	}
+	n := t.root
+	for n != nil {
+		l := size(n.Left)
+		switch {
+		case k < l:
+			n = n.Left
+		case k >= l+n.count:
+			k -= l + n.count
+			n = n.Right
+		default:
+			return n
+		}
+	}
+	return nil
+}
+
+// CountRange is how many keys are in [lo, hi].
+func (t *Tree[K, V]) CountRange(lo, hi K) int {
+	if t.cmp(lo, hi) > 0 {
+		return 0
+	}
+	return t.countLE(hi) - t.Rank(lo)
+}
+
+// Median is the middle node, the lower one of the two for an even count.
+func (t *Tree[K, V]) Median() *Node[K, V] {
+	return t.Select((t.Len() - 1) / 2)
+}
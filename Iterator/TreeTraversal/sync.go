@@ -0,0 +1,547 @@
+package main
+
+import (
+	"fmt"
+	"iter"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Tree is not safe for concurrent use: two Inserts at the same time can
+// corrupt the Parent/Left/Right links. SyncTree is the thread-safe version,
+// copy-on-write all the way down:
+//
+//   - it keeps it's own nodes, which never change once they are in the tree.
+//     A write copies just the nodes on the path from the root to the change
+//     (and the few a rotation touches), O(log n) of them, shares all the rest
+//     with the tree as it was, and then swaps in the new root atomically.
+//   - writers (Insert, Delete, DeleteOne) take turns on a mutex, so they are
+//     one at a time, but they never wait for a reader.
+//   - readers (Get, Len, Rank, ..., the iterators) take no lock at all, they
+//     load the root and go from there. So they never wait for a writer
+//     either, and a walk sees the tree exactly as it was when it started, no
+//     matter what is written meanwhile.
+//   - Snapshot() is just that root, O(1), and it costs the writers nothing.
+//
+// Tree can't do this, as with Parent links every child points at it's
+// parent: copying one node means copying it's whole subtree (see
+// snapshot.go). These nodes have no Parent, the iterators keep a stack.
+//
+// It's meant for many readers, long walks and snapshots alongside a writer.
+// A write allocates O(log n) nodes where a Tree allocates one, so for write
+// heavy use with short reads a plain Tree behind a sync.Mutex does as well
+// (see the benchmarks in sync_test.go).
+type SyncTree[K, V any] struct {
+	cmp    func(a, b K) int
+	policy DupPolicy
+
+	mu   sync.Mutex // one writer at a time
+	root atomic.Pointer[pnode[K, V]]
+}
+
+// pnode is a node of a SyncTree, the same as Node less the Parent link.
+type pnode[K, V any] struct {
+	key         K
+	val         V
+	more        []V // with DupList, see dup.go
+	count       int
+	left, right *pnode[K, V]
+	height      int
+	size        int
+}
+
+// NewSyncTree starts with a copy of t, it's comparator and DupPolicy. t itself
+// is not touched, and can go on being used on it's own.
+func NewSyncTree[K, V any](t *Tree[K, V]) *SyncTree[K, V] {
+	s := &SyncTree[K, V]{cmp: t.cmp, policy: t.policy}
+	s.root.Store(fromNode(t.root))
+	return s
+}
+
+func fromNode[K, V any](n *Node[K, V]) *pnode[K, V] {
+	if n == nil {
+		return nil
+	}
+	return &pnode[K, V]{
+		key: n.Key, val: n.Val, more: slices.Clone(n.more), count: n.count,
+		left: fromNode(n.Left), right: fromNode(n.Right),
+		height: n.height, size: n.size,
+	}
+}
+
+func (s *SyncTree[K, V]) Insert(key K, val V) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	root, err := s.insert(s.root.Load(), key, val)
+	if err != nil {
+		return err
+	}
+	s.root.Store(root)
+	return nil
+}
+
+// Delete removes key with all it's copies, like Tree.Delete.
+func (s *SyncTree[K, V]) Delete(key K) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	root, ok := s.delete(s.root.Load(), key, false)
+	if ok {
+		s.root.Store(root)
+	}
+	return ok
+}
+
+// DeleteOne removes a single copy of key, like Tree.DeleteOne.
+func (s *SyncTree[K, V]) DeleteOne(key K) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	root, ok := s.delete(s.root.Load(), key, true)
+	if ok {
+		s.root.Store(root)
+	}
+	return ok
+}
+
+// Snapshot gives the tree as of now, which never changes.
+func (s *SyncTree[K, V]) Snapshot() SyncSnapshot[K, V] {
+	return SyncSnapshot[K, V]{cmp: s.cmp, policy: s.policy, root: s.root.Load()}
+}
+
+// the read methods are those of a snapshot taken right then.
+
+func (s *SyncTree[K, V]) Get(key K) (V, bool)       { return s.Snapshot().Get(key) }
+func (s *SyncTree[K, V]) Len() int                  { return s.Snapshot().Len() }
+func (s *SyncTree[K, V]) Rank(key K) int            { return s.Snapshot().Rank(key) }
+func (s *SyncTree[K, V]) CountRange(lo, hi K) int   { return s.Snapshot().CountRange(lo, hi) }
+func (s *SyncTree[K, V]) Select(k int) (K, V, bool) { return s.Snapshot().Select(k) }
+func (s *SyncTree[K, V]) InOrder() iter.Seq2[K, V]  { return s.Snapshot().InOrder() }
+func (s *SyncTree[K, V]) Backward() iter.Seq2[K, V] { return s.Snapshot().Backward() }
+func (s *SyncTree[K, V]) Iter() *SyncIter[K, V]     { return &SyncIter[K, V]{s: s} }
+func (s *SyncTree[K, V]) ReverseIter() *SyncIter[K, V] {
+	return &SyncIter[K, V]{s: s, reverse: true}
+}
+
+// Range is [lo, hi], in order.
+func (s *SyncTree[K, V]) Range(lo, hi K) *SyncIter[K, V] {
+	return &SyncIter[K, V]{s: s, lo: lo, hi: hi, bounded: true}
+}
+
+// The writes below never change a node which is in the tree: every node on
+// the way down is copied (clone), and only the copies are changed.
+
+func clone[K, V any](n *pnode[K, V]) *pnode[K, V] {
+	c := *n
+	return &c
+}
+
+func psize[K, V any](n *pnode[K, V]) int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+func pheight[K, V any](n *pnode[K, V]) int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+func (n *pnode[K, V]) update() {
+	n.height = 1 + max(pheight(n.left), pheight(n.right))
+	n.size = n.count + psize(n.left) + psize(n.right)
+}
+
+func (n *pnode[K, V]) balance() int {
+	return pheight(n.left) - pheight(n.right)
+}
+
+// the same as Node.valueAt.
+func (n *pnode[K, V]) valueAt(i int) V {
+	if i == 0 || len(n.more) == 0 {
+		return n.val
+	}
+	return n.more[i-1]
+}
+
+// fix updates n (a copy) and rebalances it, giving the new top of it's
+// subtree. The rotations copy the children they change, n is already one.
+func fix[K, V any](n *pnode[K, V]) *pnode[K, V] {
+	n.update()
+	switch b := n.balance(); {
+	case b > 1:
+		if n.left.balance() < 0 {
+			n.left = rotateLeft(clone(n.left))
+		}
+		return rotateRight(n)
+	case b < -1:
+		if n.right.balance() > 0 {
+			n.right = rotateRight(clone(n.right))
+		}
+		return rotateLeft(n)
+	}
+	return n
+}
+
+// like Tree.rotateLeft, n is a copy already.
+func rotateLeft[K, V any](n *pnode[K, V]) *pnode[K, V] {
+	r := clone(n.right)
+	n.right = r.left
+	r.left = n
+	n.update()
+	r.update()
+	return r
+}
+
+func rotateRight[K, V any](n *pnode[K, V]) *pnode[K, V] {
+	l := clone(n.left)
+	n.left = l.right
+	l.right = n
+	n.update()
+	l.update()
+	return l
+}
+
+// insert gives the new root of the subtree n, with key in it. On an error
+// nothing was changed.
+func (s *SyncTree[K, V]) insert(n *pnode[K, V], key K, val V) (*pnode[K, V], error) {
+	if n == nil {
+		return &pnode[K, V]{key: key, val: val, count: 1, height: 1, size: 1}, nil
+	}
+	c := s.cmp(key, n.key)
+	m := clone(n)
+	switch {
+	case c < 0:
+		l, err := s.insert(n.left, key, val)
+		if err != nil {
+			return nil, err
+		}
+		m.left = l
+	case c > 0:
+		r, err := s.insert(n.right, key, val)
+		if err != nil {
+			return nil, err
+		}
+		m.right = r
+	default:
+		switch s.policy {
+		case DupReject:
+			return nil, fmt.Errorf("%w: %v", ErrDuplicateKey, key)
+		case DupReplace:
+			m.val = val
+			return m, nil
+		case DupList:
+			// Clip, so that the append can't write into the array of n.more
+			m.more = append(slices.Clip(n.more), val)
+		}
+		m.count++
+	}
+	return fix(m), nil
+}
+
+// delete takes key out of the subtree n (one copy if one), reporting whether
+// it was there.
+func (s *SyncTree[K, V]) delete(n *pnode[K, V], key K, one bool) (*pnode[K, V], bool) {
+	if n == nil {
+		return nil, false
+	}
+	c := s.cmp(key, n.key)
+	if c != 0 {
+		m := clone(n)
+		var ok bool
+		if c < 0 {
+			m.left, ok = s.delete(n.left, key, one)
+		} else {
+			m.right, ok = s.delete(n.right, key, one)
+		}
+		if !ok {
+			return n, false
+		}
+		return fix(m), true
+	}
+
+	if one && n.count > 1 {
+		m := clone(n)
+		if len(n.more) > 0 {
+			m.more = n.more[:len(n.more)-1]
+		}
+		m.count--
+		m.update()
+		return m, true
+	}
+	switch {
+	case n.left == nil:
+		return n.right, true
+	case n.right == nil:
+		return n.left, true
+	}
+	// the successor takes the place of n
+	right, succ := deleteMin(n.right)
+	m := clone(succ)
+	m.left, m.right = n.left, right
+	return fix(m), true
+}
+
+// deleteMin takes the smallest node out of the subtree n, and gives it too.
+func deleteMin[K, V any](n *pnode[K, V]) (rest, min *pnode[K, V]) {
+	if n.left == nil {
+		return n.right, n
+	}
+	m := clone(n)
+	m.left, min = deleteMin(n.left)
+	return fix(m), min
+}
+
+// SyncSnapshot is a SyncTree as it was at one moment. It is a root and
+// nothing more, the nodes are shared with the tree, which never changes them.
+// So a snapshot is free to take, and to keep around.
+type SyncSnapshot[K, V any] struct {
+	cmp    func(a, b K) int
+	policy DupPolicy
+	root   *pnode[K, V]
+}
+
+func (t SyncSnapshot[K, V]) find(key K) *pnode[K, V] {
+	n := t.root
+	for n != nil {
+		c := t.cmp(key, n.key)
+		switch {
+		case c < 0:
+			n = n.left
+		case c > 0:
+			n = n.right
+		default:
+			return n
+		}
+	}
+	return nil
+}
+
+func (t SyncSnapshot[K, V]) Get(key K) (V, bool) {
+	if n := t.find(key); n != nil {
+		return n.val, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Len counts duplicates too, like Tree.Len.
+func (t SyncSnapshot[K, V]) Len() int {
+	return psize(t.root)
+}
+
+// Rank is the number of keys < key, like Tree.Rank.
+func (t SyncSnapshot[K, V]) Rank(key K) int {
+	rank := 0
+	for n := t.root; n != nil; {
+		if t.cmp(key, n.key) <= 0 {
+			n = n.left
+		} else {
+			rank += psize(n.left) + n.count
+			n = n.right
+		}
+	}
+	return rank
+}
+
+// CountRange is how many keys are in [lo, hi].
+func (t SyncSnapshot[K, V]) CountRange(lo, hi K) int {
+	if t.cmp(lo, hi) > 0 {
+		return 0
+	}
+	le := 0
+	for n := t.root; n != nil; {
+		if t.cmp(hi, n.key) < 0 {
+			n = n.left
+		} else {
+			le += psize(n.left) + n.count
+			n = n.right
+		}
+	}
+	return le - t.Rank(lo)
+}
+
+// Select gives the key and value at index k (0 based) in sorted order, like
+// Tree.Select.
+func (t SyncSnapshot[K, V]) Select(k int) (K, V, bool) {
+	for n := t.root; n != nil && k >= 0; {
+		l := psize(n.left)
+		switch {
+		case k < l:
+			n = n.left
+		case k >= l+n.count:
+			k -= l + n.count
+			n = n.right
+		default:
+			return n.key, n.valueAt(k - l), true
+		}
+	}
+	var key K
+	var val V
+	return key, val, false
+}
+
+func (t SyncSnapshot[K, V]) Iter() *SyncIter[K, V] {
+	return &SyncIter[K, V]{snap: &t}
+}
+
+func (t SyncSnapshot[K, V]) ReverseIter() *SyncIter[K, V] {
+	return &SyncIter[K, V]{snap: &t, reverse: true}
+}
+
+func (t SyncSnapshot[K, V]) Range(lo, hi K) *SyncIter[K, V] {
+	return &SyncIter[K, V]{snap: &t, lo: lo, hi: hi, bounded: true}
+}
+
+// InOrder and Backward go by Pair, not Pairs (seq.go), which would make a
+// Node for each step.
+func (t SyncSnapshot[K, V]) InOrder() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for it := t.Iter(); it.Next(); {
+			if !yield(it.Pair()) {
+				return
+			}
+		}
+	}
+}
+
+func (t SyncSnapshot[K, V]) Backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for it := t.ReverseIter(); it.Next(); {
+			if !yield(it.Pair()) {
+				return
+			}
+		}
+	}
+}
+
+// Tree makes a Tree of the snapshot, for everything else a Tree can do
+// (encode.go, WriteDOT, the other iterators ...). It is a copy, O(n).
+func (t SyncSnapshot[K, V]) Tree() *Tree[K, V] {
+	return &Tree[K, V]{root: toNode(t.root, nil), cmp: t.cmp, policy: t.policy}
+}
+
+func toNode[K, V any](n *pnode[K, V], parent *Node[K, V]) *Node[K, V] {
+	if n == nil {
+		return nil
+	}
+	c := &Node[K, V]{
+		Key: n.key, Val: n.val, more: slices.Clone(n.more), count: n.count,
+		Parent: parent, height: n.height, size: n.size,
+	}
+	c.Left, c.Right = toNode(n.left, c), toNode(n.right, c)
+	return c
+}
+
+// SyncIter walks one version of a SyncTree: the one of the snapshot it was
+// made from, or for SyncTree.Iter and friends the latest one when the walk
+// starts (or is Reset). Nothing written after that shows up, and nothing
+// written can break the walk. With no Parent links to follow, it keeps the
+// path to the next node on a stack, O(log n) of them.
+type SyncIter[K, V any] struct {
+	s       *SyncTree[K, V]     // to start from the latest version
+	snap    *SyncSnapshot[K, V] // or from this one
+	lo, hi  K
+	bounded bool
+	reverse bool
+
+	started bool
+	cmp     func(a, b K) int
+	stack   []*pnode[K, V]
+	node    *pnode[K, V]
+	rep     int // which copy of key, for duplicate keys
+}
+
+func (it *SyncIter[K, V]) Next() bool {
+	if !it.started {
+		it.start()
+	} else if n := it.node; n != nil && it.rep+1 < n.count {
+		it.rep++ // more copies of the same key to go
+		return true
+	}
+	if len(it.stack) == 0 {
+		it.node = nil
+		return false
+	}
+	n := it.stack[len(it.stack)-1]
+	it.stack = it.stack[:len(it.stack)-1]
+	if it.reverse {
+		it.pushRight(n.left)
+	} else {
+		it.pushLeft(n.right)
+	}
+	if it.bounded && it.cmp(n.key, it.hi) > 0 {
+		it.stack, it.node = nil, nil
+		return false
+	}
+	it.node, it.rep = n, 0
+	return true
+}
+
+func (it *SyncIter[K, V]) start() {
+	t := it.snap
+	if t == nil {
+		snap := it.s.Snapshot()
+		t = &snap
+	}
+	it.started, it.cmp, it.stack, it.node = true, t.cmp, it.stack[:0], nil
+	switch {
+	case it.reverse:
+		it.pushRight(t.root)
+	case it.bounded:
+		// the path to the first key >= lo, less where it went right
+		for n := t.root; n != nil; {
+			if t.cmp(n.key, it.lo) >= 0 {
+				it.stack = append(it.stack, n)
+				n = n.left
+			} else {
+				n = n.right
+			}
+		}
+	default:
+		it.pushLeft(t.root)
+	}
+}
+
+func (it *SyncIter[K, V]) pushLeft(n *pnode[K, V]) {
+	for ; n != nil; n = n.left {
+		it.stack = append(it.stack, n)
+	}
+}
+
+func (it *SyncIter[K, V]) pushRight(n *pnode[K, V]) {
+	for ; n != nil; n = n.right {
+		it.stack = append(it.stack, n)
+	}
+}
+
+// copies are walked last to first when going backwards.
+func (it *SyncIter[K, V]) copyIndex() int {
+	if it.reverse {
+		return it.node.count - 1 - it.rep
+	}
+	return it.rep
+}
+
+// Value is a Node w/o any links, as a SyncTree has none of those.
+func (it *SyncIter[K, V]) Value() *Node[K, V] {
+	k, v := it.Pair()
+	return &Node[K, V]{Key: k, Val: v, count: 1}
+}
+
+func (it *SyncIter[K, V]) Pair() (K, V) {
+	return it.node.key, it.node.valueAt(it.copyIndex())
+}
+
+// Err is always nil, nothing can change under a SyncIter.
+func (it *SyncIter[K, V]) Err() error {
+	return nil
+}
+
+// Reset starts the walk again, from the latest version unless the iterator
+// came from a snapshot.
+func (it *SyncIter[K, V]) Reset() {
+	it.started, it.node, it.rep = false, nil, 0
+}
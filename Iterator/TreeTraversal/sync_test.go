@@ -0,0 +1,424 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// writers insert and delete while readers walk with SyncIter and take
+// snapshots, run with -race.
+func TestSyncTreeConcurrent(t *testing.T) {
+	st := NewSyncTree(NewOrderedTree[int, int]())
+	const writers, readers, ops = 4, 4, 2000
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := rand.New(rand.NewPCG(uint64(w), 1))
+			for range ops {
+				k := r.IntN(500)
+				if r.IntN(3) == 0 {
+					st.Delete(k)
+				} else {
+					st.Insert(k, k)
+				}
+			}
+		}()
+	}
+	for range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				prev := -1
+				for it := st.Iter(); it.Next(); {
+					k, v := it.Pair()
+					if k <= prev || v != k {
+						t.Errorf("SyncIter gave %d (val %d) after %d", k, v, prev)
+						return
+					}
+					prev = k
+				}
+				prev = 501
+				for k := range st.Backward() {
+					if k >= prev {
+						t.Errorf("reverse SyncIter gave %d after %d", k, prev)
+						return
+					}
+					prev = k
+				}
+				for it := st.Range(100, 200); it.Next(); {
+					if k, _ := it.Pair(); k < 100 || k > 200 {
+						t.Errorf("Range gave %d", k)
+						return
+					}
+				}
+
+				// a snapshot does not change under the writers
+				snap := st.Snapshot()
+				var keys []int
+				for k := range snap.InOrder() {
+					keys = append(keys, k)
+				}
+				if len(keys) != snap.Len() {
+					t.Errorf("snapshot has %d keys, Len says %d", len(keys), snap.Len())
+					return
+				}
+				i := 0
+				for k := range snap.InOrder() {
+					if i >= len(keys) || keys[i] != k {
+						t.Error("snapshot changed while being read")
+						return
+					}
+					i++
+				}
+				st.Get(rand.IntN(500))
+				st.Rank(rand.IntN(500))
+				st.Len()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// and what is left is a proper tree
+	prev, n := -1, 0
+	for k := range st.InOrder() {
+		if k <= prev {
+			t.Fatalf("%d after %d", k, prev)
+		}
+		prev = k
+		n++
+	}
+	if n != st.Len() {
+		t.Fatalf("walked %d keys, Len says %d", n, st.Len())
+	}
+}
+
+// a SyncIter walks the tree as it was when it started, deletes of the key it
+// is on (or the next ones) don't show up in the walk.
+func TestSyncIterDeleteCurrent(t *testing.T) {
+	st := NewSyncTree(NewOrderedTree[int, int]())
+	for i := range 10 {
+		st.Insert(i, i)
+	}
+	var got []int
+	for it := st.Iter(); it.Next(); {
+		k, _ := it.Pair()
+		got = append(got, k)
+		st.Delete(k)
+		st.Delete(k + 1)
+	}
+	if len(got) != 10 {
+		t.Fatalf("got %v, want 0 to 9", got)
+	}
+	for i, k := range got {
+		if k != i {
+			t.Fatalf("got %v, want 0 to 9", got)
+		}
+	}
+	if st.Len() != 0 {
+		t.Fatalf("Len %d after deleting all", st.Len())
+	}
+}
+
+// check walks a SyncTree node by node: order, heights, sizes and AVL balance.
+func check[K, V any](t *testing.T, cmp func(a, b K) int, n *pnode[K, V], lo, hi *K) {
+	t.Helper()
+	if n == nil {
+		return
+	}
+	if lo != nil && cmp(n.key, *lo) <= 0 || hi != nil && cmp(n.key, *hi) >= 0 {
+		t.Fatalf("%v out of order", n.key)
+	}
+	check(t, cmp, n.left, lo, &n.key)
+	check(t, cmp, n.right, &n.key, hi)
+	if b := n.balance(); b < -1 || b > 1 {
+		t.Fatalf("%v out of balance: %d", n.key, b)
+	}
+	h := 1 + max(pheight(n.left), pheight(n.right))
+	if s := n.count + psize(n.left) + psize(n.right); n.height != h || n.size != s {
+		t.Fatalf("%v has height %d size %d, want %d %d", n.key, n.height, n.size, h, s)
+	}
+}
+
+// every snapshot stays what the tree was when it was taken, while the writes
+// go on copying paths.
+func TestSyncTreeSnapshots(t *testing.T) {
+	st := NewSyncTree(NewOrderedTree[int, int]())
+	st.policy = DupCount
+	type version struct {
+		snap SyncSnapshot[int, int]
+		want map[int]int // key -> count
+	}
+	var versions []version
+	want := map[int]int{}
+	r := rand.New(rand.NewPCG(1, 2))
+	for i := range 3000 {
+		k := r.IntN(200)
+		switch r.IntN(4) {
+		case 0:
+			if st.Delete(k) != (want[k] > 0) {
+				t.Fatalf("Delete(%d) with %d copies", k, want[k])
+			}
+			delete(want, k)
+		case 1:
+			if st.DeleteOne(k) != (want[k] > 0) {
+				t.Fatalf("DeleteOne(%d) with %d copies", k, want[k])
+			}
+			if want[k]--; want[k] <= 0 {
+				delete(want, k)
+			}
+		default:
+			st.Insert(k, k)
+			want[k]++
+		}
+		if i%100 == 0 {
+			versions = append(versions, version{st.Snapshot(), maps.Clone(want)})
+		}
+	}
+	versions = append(versions, version{st.Snapshot(), want})
+
+	for i, v := range versions {
+		check(t, cmp.Compare[int], v.snap.root, nil, nil)
+		got := map[int]int{}
+		n := 0
+		for k := range v.snap.InOrder() {
+			got[k]++
+			n++
+		}
+		if !maps.Equal(got, v.want) {
+			t.Fatalf("snapshot %d: got %v, want %v", i, got, v.want)
+		}
+		if n != v.snap.Len() {
+			t.Fatalf("snapshot %d: walked %d, Len %d", i, n, v.snap.Len())
+		}
+		for k := range 200 {
+			if c := v.snap.CountRange(k, k); c != v.want[k] {
+				t.Fatalf("snapshot %d: CountRange(%d, %d) = %d, want %d", i, k, k, c, v.want[k])
+			}
+		}
+	}
+}
+
+// the DupPolicy of the Tree it started from carries over.
+func TestSyncTreeDups(t *testing.T) {
+	st := NewSyncTree(NewOrderedTree[string, int]().WithPolicy(DupList))
+	for i := range 3 {
+		st.Insert("a", i)
+	}
+	st.Insert("b", 9)
+	snap := st.Snapshot()
+	st.DeleteOne("a")
+	st.Insert("a", 7)
+
+	var got []int
+	for _, v := range snap.InOrder() {
+		got = append(got, v)
+	}
+	if !slices.Equal(got, []int{0, 1, 2, 9}) {
+		t.Fatalf("snapshot: got %v", got)
+	}
+	got = got[:0]
+	for _, v := range st.InOrder() {
+		got = append(got, v)
+	}
+	if !slices.Equal(got, []int{0, 1, 7, 9}) {
+		t.Fatalf("got %v", got)
+	}
+	got = got[:0]
+	for _, v := range st.Backward() {
+		got = append(got, v)
+	}
+	if !slices.Equal(got, []int{9, 7, 1, 0}) {
+		t.Fatalf("backward: got %v", got)
+	}
+	if _, v, _ := st.Select(2); v != 7 {
+		t.Fatalf("Select(2) = %d", v)
+	}
+
+	// and back to a Tree, with the list
+	tree := st.Snapshot().Tree()
+	if vals := tree.find("a").Values(); !slices.Equal(vals, []int{0, 1, 7}) {
+		t.Fatalf("Tree values %v", vals)
+	}
+
+	rej := NewSyncTree(NewOrderedTree[string, int]().WithPolicy(DupReject))
+	rej.Insert("a", 1)
+	if err := rej.Insert("a", 2); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("got %v, want ErrDuplicateKey", err)
+	}
+	if v, _ := rej.Get("a"); v != 1 || rej.Len() != 1 {
+		t.Fatalf("rejected insert changed the tree: %d, Len %d", v, rej.Len())
+	}
+}
+
+// the baseline: a plain Tree behind one sync.Mutex.
+type mutexTree struct {
+	mu   sync.Mutex
+	tree *Tree[int, int]
+}
+
+func (m *mutexTree) Insert(k, v int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tree.Insert(k, v)
+}
+
+func (m *mutexTree) Get(k int) (int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tree.Get(k)
+}
+
+// Walk holds the lock for the whole walk, which is the only safe way with a
+// plain Tree.
+func (m *mutexTree) Walk(f func(k, v int)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, v := range m.tree.InOrder() {
+		f(k, v)
+	}
+}
+
+const benchKeys = 10000
+
+func newBenchSync() *SyncTree[int, int] {
+	st := NewSyncTree(NewOrderedTree[int, int]())
+	for i := range benchKeys {
+		st.Insert(i, i)
+	}
+	return st
+}
+
+func newBenchMutex() *mutexTree {
+	m := &mutexTree{tree: NewOrderedTree[int, int]()}
+	for i := range benchKeys {
+		m.Insert(i, i)
+	}
+	return m
+}
+
+// 1 write in 10, the read-mostly case SyncTree is for.
+func BenchmarkSyncTreeGetMostly(b *testing.B) {
+	st := newBenchSync()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewPCG(rand.Uint64(), 0))
+		for pb.Next() {
+			k := r.IntN(benchKeys)
+			if r.IntN(10) == 0 {
+				st.Insert(k, k)
+			} else {
+				st.Get(k)
+			}
+		}
+	})
+}
+
+func BenchmarkMutexTreeGetMostly(b *testing.B) {
+	m := newBenchMutex()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewPCG(rand.Uint64(), 0))
+		for pb.Next() {
+			k := r.IntN(benchKeys)
+			if r.IntN(10) == 0 {
+				m.Insert(k, k)
+			} else {
+				m.Get(k)
+			}
+		}
+	})
+}
+
+// writers keep inserting while every other goroutine walks the whole tree.
+func BenchmarkSyncTreeIterWithWriter(b *testing.B) {
+	st := newBenchSync()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				st.Insert(i%benchKeys, i)
+			}
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for it := st.Iter(); it.Next(); {
+			}
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-done
+}
+
+func BenchmarkMutexTreeIterWithWriter(b *testing.B) {
+	m := newBenchMutex()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				m.Insert(i%benchKeys, i)
+			}
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.Walk(func(k, v int) {})
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-done
+}
+
+// readers walking a snapshot, which they can keep as long as they like.
+func BenchmarkSyncTreeSnapshotIter(b *testing.B) {
+	st := newBenchSync()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for range st.Snapshot().InOrder() {
+			}
+		}
+	})
+}
+
+// take a snapshot, then write: the snapshot plus writer case. A SyncTree
+// copies O(log n) nodes for the write, a Tree copies all of them (see
+// unshare in snapshot.go).
+func BenchmarkSyncTreeSnapshotWithWriter(b *testing.B) {
+	st := newBenchSync()
+	b.ResetTimer()
+	for i := range b.N {
+		st.Snapshot()
+		st.Insert(i%benchKeys, i)
+	}
+}
+
+func BenchmarkMutexTreeSnapshotWithWriter(b *testing.B) {
+	m := newBenchMutex()
+	b.ResetTimer()
+	for i := range b.N {
+		m.mu.Lock()
+		m.tree.Snapshot()
+		m.mu.Unlock()
+		m.Insert(i%benchKeys, i)
+	}
+}
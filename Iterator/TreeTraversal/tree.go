@@ -0,0 +1,245 @@
+package main
+
+import "cmp"
+
+// The plain BST we started with (AddNode) degenerates into a linked list when
+// the keys come in sorted order, e.g. inserting 1..N gives a tree of height N.
+// So here is the same Node, but generic and kept balanced (AVL) by a Tree
+// which owns the root and the comparator.
+//
+// The Parent links are kept correct across rotations, so NodeIter can still
+// walk the tree w/o any extra stack.
+
+type Node[K, V any] struct {
+	Key    K
+	Val    V
+	Left   *Node[K, V]
+	Right  *Node[K, V]
+	Parent *Node[K, V]
+	height int
+	size   int // number of keys in the subtree rooted here, see stats.go
+	count  int // how many times Key is in the tree, see dup.go
+	more   []V // values after Val, with the DupList policy
+}
+
+type Tree[K, V any] struct {
+	root   *Node[K, V]
+	cmp    func(a, b K) int // < 0 if a < b, 0 if a == b, > 0 if a > b
+	policy DupPolicy
+
+	mods   uint64 // bumped on every change of shape, see snapshot.go
+	shared bool   // nodes are shared with a snapshot, copy before writing
+}
+
+// comparator is pluggable, so keys need not be ordered types.
+func NewTree[K, V any](cmp func(a, b K) int) *Tree[K, V] {
+	return &Tree[K, V]{cmp: cmp}
+}
+
+// for the common case where keys are ints, strings etc.
+func NewOrderedTree[K cmp.Ordered, V any]() *Tree[K, V] {
+	return NewTree[K, V](cmp.Compare[K])
+}
+
+func (t *Tree[K, V]) Root() *Node[K, V] {
+	return t.root
+}
+
+// Len counts duplicates too (with DupCount and DupList).
+func (t *Tree[K, V]) Len() int {
+	return size(t.root)
+}
+
+func (t *Tree[K, V]) find(key K) *Node[K, V] {
+	n := t.root
+	for n != nil {
+		c := t.cmp(key, n.Key)
+		switch {
+		case c < 0:
+			n = n.Left
+		case c > 0:
+			n = n.Right
+		default:
+			return n
+		}
+	}
+	return nil
+}
+
+func (t *Tree[K, V]) Get(key K) (V, bool) {
+	if n := t.find(key); n != nil {
+		return n.Val, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Insert adds key to the tree. What happens if the key is already present
+// depends on the DupPolicy of the tree, by default it's value is replaced, so
+// the tree behaves like a map. Only DupReject gives an error.
+func (t *Tree[K, V]) Insert(key K, val V) error {
+	if t.find(key) != nil {
+		return t.insertDup(key, val)
+	}
+	t.unshare()
+	var parent *Node[K, V]
+	n := t.root
+	c := 0
+	for n != nil {
+		parent = n
+		c = t.cmp(key, n.Key)
+		if c < 0 {
+			n = n.Left
+		} else {
+			n = n.Right
+		}
+	}
+
+	node := &Node[K, V]{Key: key, Val: val, Parent: parent, height: 1, size: 1, count: 1}
+	switch {
+	case parent == nil:
+		t.root = node
+	case c < 0:
+		parent.Left = node
+	default:
+		parent.Right = node
+	}
+	t.mods++
+	t.rebalance(parent)
+	return nil
+}
+
+// Delete removes key from the tree and reports whether it was there. All the
+// duplicates of key go with it, see DeleteOne to remove just one.
+func (t *Tree[K, V]) Delete(key K) bool {
+	if t.find(key) == nil {
+		return false
+	}
+	t.unshare()
+	n := t.find(key)
+	// a node with two children takes over the contents of it's successor,
+	// and then it is the successor (which has no left child) that goes away.
+	if n.Left != nil && n.Right != nil {
+		s := n.Right
+		for s.Left != nil {
+			s = s.Left
+		}
+		n.Key, n.Val, n.count, n.more = s.Key, s.Val, s.count, s.more
+		n = s
+	}
+
+	child := n.Left
+	if child == nil {
+		child = n.Right
+	}
+	parent := n.Parent
+	if child != nil {
+		child.Parent = parent
+	}
+	t.replaceChild(parent, n, child)
+	t.mods++
+	t.rebalance(parent)
+	return true
+}
+
+// replaceChild hooks repl into the place old had under parent.
+func (t *Tree[K, V]) replaceChild(parent, old, repl *Node[K, V]) {
+	switch {
+	case parent == nil:
+		t.root = repl
+	case parent.Left == old:
+		parent.Left = repl
+	default:
+		parent.Right = repl
+	}
+}
+
+func height[K, V any](n *Node[K, V]) int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+func size[K, V any](n *Node[K, V]) int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+// update recomputes what a node caches about it's subtree. Every change in
+// the tree (insert, delete, rotation) calls it bottom up on the nodes whose
+// subtree changed.
+func (n *Node[K, V]) update() {
+	n.height = 1 + max(height(n.Left), height(n.Right))
+	n.size = n.count + size(n.Left) + size(n.Right)
+}
+
+func (n *Node[K, V]) balance() int {
+	return height(n.Left) - height(n.Right)
+}
+
+// rebalance walks from n up to the root, fixing heights and rotating
+// wherever the AVL invariant (|balance| <= 1) got broken.
+func (t *Tree[K, V]) rebalance(n *Node[K, V]) {
+	for n != nil {
+		n.update()
+		switch b := n.balance(); {
+		case b > 1:
+			if n.Left.balance() < 0 {
+				t.rotateLeft(n.Left)
+			}
+			n = t.rotateRight(n)
+		case b < -1:
+			if n.Right.balance() > 0 {
+				t.rotateRight(n.Right)
+			}
+			n = t.rotateLeft(n)
+		}
+		n = n.Parent
+	}
+}
+
+// rotateLeft lifts the right child of n into it's place:
+//
+//	  n              r
+//	 / \            / \
+//	a   r    =>    n   c
+//	   / \        / \
+//	  b   c      a   b
+func (t *Tree[K, V]) rotateLeft(n *Node[K, V]) *Node[K, V] {
+	r := n.Right
+	n.Right = r.Left
+	if r.Left != nil {
+		r.Left.Parent = n
+	}
+	r.Parent = n.Parent
+	t.replaceChild(n.Parent, n, r)
+	r.Left = n
+	n.Parent = r
+	n.update()
+	r.update()
+	return r
+}
+
+// mirror image of rotateLeft.
+func (t *Tree[K, V]) rotateRight(n *Node[K, V]) *Node[K, V] {
+	l := n.Left
+	n.Left = l.Right
+	if l.Right != nil {
+		l.Right.Parent = n
+	}
+	l.Parent = n.Parent
+	t.replaceChild(n.Parent, n, l)
+	l.Right = n
+	n.Parent = l
+	n.update()
+	l.update()
+	return l
+}
+
+// Height is just for checking that the tree really stays balanced.
+func (t *Tree[K, V]) Height() int {
+	return height(t.root)
+}
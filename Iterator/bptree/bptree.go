@@ -0,0 +1,450 @@
+// Package bptree is an ordered index of int64 keys and []byte values kept
+// in a single file, for when the in-memory tree of TreeTraversal gets bigger
+// than RAM.
+//
+// It is a B+tree: all the values are in the leaves, which are linked left to
+// right, so iterating is just reading leaf after leaf. The iterator has the
+// same Next()/Value()/Reset()/Err() contract as the TreeTraversal iterators,
+// so the code consuming them does not change.
+//
+// Pages are read through a buffer pool holding a bounded number of them, and
+// every write goes through a write-ahead log first (see wal.go), so a crash
+// never leaves a half written tree behind.
+//
+// A DB is safe for concurrent use. Deleting never merges half empty pages,
+// but a leaf which gets empty is unlinked from the tree and it's page goes on
+// a free list, for the next split to reuse. So inserting and deleting over
+// and over doesn't grow the file w/o bound (it never shrinks either).
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+var ErrClosed = errors.New("bptree: closed")
+
+type Options struct {
+	PoolPages int // pages kept in memory, 256 (1MB) if not set
+}
+
+type DB struct {
+	mu   sync.Mutex
+	file *os.File
+	wal  wal
+	pool *pool
+	meta meta
+	mods uint64 // bumped on every write, see Iter
+	err  error  // sticky: once a write failed the DB is unusable
+}
+
+// Open opens the index in path, creating it if needed. The log lives next
+// to it in path + ".wal".
+func Open(path string, opts *Options) (*DB, error) {
+	capacity := 256
+	if opts != nil && opts.PoolPages > 0 {
+		capacity = opts.PoolPages
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	walFile, err := os.OpenFile(path+".wal", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	db := &DB{file: file, wal: wal{walFile}, pool: newPool(file, capacity)}
+	if err := db.open(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *DB) open() error {
+	if err := db.recover(); err != nil {
+		return err
+	}
+	st, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+	if st.Size() == 0 {
+		// brand new: an empty leaf as the root
+		db.meta = meta{root: 1, pages: 2}
+		db.pool.add(1, &node{leaf: true}, true)
+		return db.commit()
+	}
+	buf := make([]byte, PageSize)
+	if _, err := db.file.ReadAt(buf, 0); err != nil {
+		return err
+	}
+	db.meta, err = decodeMeta(buf)
+	return err
+}
+
+// recover replays a complete log left by a commit which did not finish.
+func (db *DB) recover() error {
+	pages, err := db.wal.read()
+	if err != nil {
+		return err
+	}
+	if err := db.apply(pages); err != nil {
+		return err
+	}
+	return db.wal.clear()
+}
+
+func (db *DB) apply(pages []walPage) error {
+	for _, p := range pages {
+		if _, err := db.file.WriteAt(p.data, int64(p.id)*PageSize); err != nil {
+			return err
+		}
+	}
+	if len(pages) == 0 {
+		return nil
+	}
+	return db.file.Sync()
+}
+
+// commit makes everything dirty in the pool (and the meta page) durable.
+func (db *DB) commit() error {
+	var pages []walPage
+	buf := make([]byte, PageSize)
+	db.meta.encode(buf)
+	pages = append(pages, walPage{0, buf})
+	dirty := db.pool.dirty()
+	for _, f := range dirty {
+		buf := make([]byte, PageSize)
+		f.node.encode(buf)
+		pages = append(pages, walPage{f.id, buf})
+	}
+
+	err := db.wal.write(pages)
+	if err == nil {
+		err = db.apply(pages)
+	}
+	if err == nil {
+		err = db.wal.clear()
+	}
+	if err != nil {
+		return db.fail(err)
+	}
+	for _, f := range dirty {
+		f.dirty = false
+	}
+	db.pool.trim()
+	return nil
+}
+
+// fail is for a write which went wrong half way: memory is now ahead of the
+// disk, don't go on from there.
+func (db *DB) fail(err error) error {
+	db.err = fmt.Errorf("bptree: write failed, reopen the DB: %w", err)
+	db.pool.reset()
+	return db.err
+}
+
+func (db *DB) check() error {
+	if db.err != nil {
+		return db.err
+	}
+	if db.file == nil {
+		return ErrClosed
+	}
+	return nil
+}
+
+// childIndex is which child of an internal node has key: the number of
+// separator keys <= key.
+func childIndex(n *node, key int64) int {
+	return sort.Search(len(n.keys), func(i int) bool { return n.keys[i] > key })
+}
+
+// descend goes from the root to the leaf where key is (or would be), giving
+// the path of internal pages and the child index taken in each.
+func (db *DB) descend(key int64) (path []pageID, idx []int, leaf pageID, err error) {
+	id := db.meta.root
+	for {
+		n, err := db.pool.get(id)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if n.leaf {
+			return path, idx, id, nil
+		}
+		i := childIndex(n, key)
+		path, idx = append(path, id), append(idx, i)
+		id = n.children[i]
+	}
+}
+
+func (db *DB) Get(key int64) ([]byte, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.check(); err != nil {
+		return nil, false, err
+	}
+	defer db.pool.trim()
+	_, _, leafID, err := db.descend(key)
+	if err != nil {
+		return nil, false, err
+	}
+	leaf, err := db.pool.get(leafID)
+	if err != nil {
+		return nil, false, err
+	}
+	i := sort.Search(len(leaf.keys), func(i int) bool { return leaf.keys[i] >= key })
+	if i < len(leaf.keys) && leaf.keys[i] == key {
+		return append([]byte(nil), leaf.vals[i]...), true, nil
+	}
+	return nil, false, nil
+}
+
+func (db *DB) Len() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return int(db.meta.count)
+}
+
+// Put inserts key, or replaces it's value. It is durable when it returns.
+func (db *DB) Put(key int64, val []byte) error {
+	if len(val) > MaxValueLen {
+		return ErrValueTooLarge
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.check(); err != nil {
+		return err
+	}
+	path, idx, leafID, err := db.descend(key)
+	if err != nil {
+		return err
+	}
+	leaf, err := db.pool.get(leafID)
+	if err != nil {
+		return err
+	}
+	val = append([]byte(nil), val...)
+	i := sort.Search(len(leaf.keys), func(i int) bool { return leaf.keys[i] >= key })
+	if i < len(leaf.keys) && leaf.keys[i] == key {
+		leaf.vals[i] = val
+	} else {
+		leaf.keys = insertAt(leaf.keys, i, key)
+		leaf.vals = insertAt(leaf.vals, i, val)
+		db.meta.count++
+	}
+	db.pool.markDirty(leafID)
+	db.mods++
+
+	if leaf.encodedLen() > PageSize {
+		sep, right, err := db.splitLeaf(leaf)
+		if err == nil {
+			err = db.insertSeparator(path, idx, sep, right)
+		}
+		if err != nil {
+			return db.fail(err)
+		}
+	}
+	return db.commit()
+}
+
+// Delete removes key, reporting if it was there.
+func (db *DB) Delete(key int64) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.check(); err != nil {
+		return false, err
+	}
+	path, idx, leafID, err := db.descend(key)
+	if err != nil {
+		return false, err
+	}
+	leaf, err := db.pool.get(leafID)
+	if err != nil {
+		return false, err
+	}
+	i := sort.Search(len(leaf.keys), func(i int) bool { return leaf.keys[i] >= key })
+	if i == len(leaf.keys) || leaf.keys[i] != key {
+		db.pool.trim()
+		return false, nil
+	}
+	leaf.keys = append(leaf.keys[:i], leaf.keys[i+1:]...)
+	leaf.vals = append(leaf.vals[:i], leaf.vals[i+1:]...)
+	db.meta.count--
+	db.pool.markDirty(leafID)
+	db.mods++
+	if len(leaf.keys) == 0 && len(path) > 0 {
+		if err := db.unlinkLeaf(path, idx, leafID, leaf); err != nil {
+			return false, db.fail(err)
+		}
+	}
+	return true, db.commit()
+}
+
+// unlinkLeaf takes an empty leaf (not the root) out of the leaf chain and out
+// of it's parent, and frees it's page. An internal node left w/o children
+// goes the same way, and a root left with a single child hands over to it.
+func (db *DB) unlinkLeaf(path []pageID, idx []int, id pageID, leaf *node) error {
+	// the leaf before it is the rightmost one under the closest left sibling
+	// on the path; the leftmost leaf has none, and nothing links to it.
+	level := len(path) - 1
+	for level >= 0 && idx[level] == 0 {
+		level--
+	}
+	if level >= 0 {
+		n, _ := db.pool.get(path[level]) // on the path, so it is in the pool
+		prevID := n.children[idx[level]-1]
+		for {
+			prev, err := db.pool.get(prevID)
+			if err != nil {
+				return err
+			}
+			if prev.leaf {
+				prev.next = leaf.next
+				db.pool.markDirty(prevID)
+				break
+			}
+			prevID = prev.children[len(prev.children)-1]
+		}
+	}
+	db.free(id)
+
+	for level := len(path) - 1; level >= 0; level-- {
+		pid, i := path[level], idx[level]
+		n, _ := db.pool.get(pid)
+		n.children = append(n.children[:i], n.children[i+1:]...)
+		if len(n.keys) > 0 {
+			// the separator left of child i, or right of it for child 0
+			k := max(i-1, 0)
+			n.keys = append(n.keys[:k], n.keys[k+1:]...)
+		}
+		db.pool.markDirty(pid)
+		if len(n.children) > 0 || level == 0 {
+			break
+		}
+		db.free(pid)
+	}
+
+	for {
+		root, err := db.pool.get(db.meta.root)
+		if err != nil {
+			return err
+		}
+		if root.leaf || len(root.children) != 1 {
+			return nil
+		}
+		db.free(db.meta.root)
+		db.meta.root = root.children[0]
+	}
+}
+
+// free puts page id at the head of the free list.
+func (db *DB) free(id pageID) {
+	db.pool.put(id, &node{free: true, next: db.meta.free})
+	db.meta.free = id
+}
+
+// allocate gives n a page, the first free one if any, else a new one at the
+// end of the file.
+func (db *DB) allocate(n *node) (pageID, error) {
+	id := db.meta.free
+	if id == 0 {
+		id = pageID(db.meta.pages)
+		db.meta.pages++
+		db.pool.add(id, n, true)
+		return id, nil
+	}
+	f, err := db.pool.get(id)
+	if err != nil {
+		return 0, err
+	}
+	if !f.free {
+		return 0, fmt.Errorf("%w: page %d on the free list is in use", ErrCorrupt, id)
+	}
+	db.meta.free = f.next
+	db.pool.put(id, n)
+	return id, nil
+}
+
+// splitLeaf moves the upper half (by bytes) of leaf to a new page, linked
+// in right after it, and gives the first key of the new page.
+func (db *DB) splitLeaf(leaf *node) (int64, pageID, error) {
+	half, size, mid := leaf.encodedLen()/2, leafHeader, 0
+	for mid < len(leaf.keys)-1 && size < half {
+		size += 10 + len(leaf.vals[mid])
+		mid++
+	}
+	mid = max(mid, 1)
+	right := &node{
+		leaf: true,
+		keys: append([]int64(nil), leaf.keys[mid:]...),
+		vals: append([][]byte(nil), leaf.vals[mid:]...),
+		next: leaf.next,
+	}
+	id, err := db.allocate(right)
+	if err != nil {
+		return 0, 0, err
+	}
+	leaf.keys, leaf.vals = leaf.keys[:mid], leaf.vals[:mid]
+	leaf.next = id
+	return right.keys[0], id, nil
+}
+
+// insertSeparator hooks a new right sibling into the parent at the end of
+// path, splitting internal nodes (and growing a new root) as needed.
+func (db *DB) insertSeparator(path []pageID, idx []int, sep int64, right pageID) error {
+	for level := len(path) - 1; level >= 0; level-- {
+		id := path[level]
+		n, _ := db.pool.get(id) // on the path, so it is in the pool
+		i := idx[level]
+		n.keys = insertAt(n.keys, i, sep)
+		n.children = insertAt(n.children, i+1, right)
+		db.pool.markDirty(id)
+		if len(n.keys) <= maxInternalKey {
+			return nil
+		}
+		// the middle key moves up, it's left and right go to the two halves
+		mid := len(n.keys) / 2
+		sep = n.keys[mid]
+		r := &node{
+			keys:     append([]int64(nil), n.keys[mid+1:]...),
+			children: append([]pageID(nil), n.children[mid+1:]...),
+		}
+		n.keys, n.children = n.keys[:mid], n.children[:mid+1]
+		var err error
+		if right, err = db.allocate(r); err != nil {
+			return err
+		}
+	}
+	// the root itself was split
+	root, err := db.allocate(&node{keys: []int64{sep}, children: []pageID{db.meta.root, right}})
+	if err != nil {
+		return err
+	}
+	db.meta.root = root
+	return nil
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+	err := errors.Join(db.file.Close(), db.wal.f.Close())
+	db.file = nil
+	return err
+}
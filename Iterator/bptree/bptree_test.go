@@ -0,0 +1,348 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func open(t *testing.T, path string, pages int) *DB {
+	t.Helper()
+	db, err := Open(path, &Options{PoolPages: pages})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func val(k int64) []byte {
+	return []byte(fmt.Sprintf("value %d %s", k, bytes.Repeat([]byte("x"), int(k%97))))
+}
+
+// logOnly does the first step of a commit for a Put of key and stops, as if
+// the process died right after the log was synced.
+func logOnly(t *testing.T, db *DB, key int64) {
+	t.Helper()
+	_, _, leafID, err := db.descend(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := db.pool.get(leafID)
+	leaf.keys = append(leaf.keys, key)
+	leaf.vals = append(leaf.vals, val(key))
+	db.meta.count++
+	db.pool.markDirty(leafID)
+
+	buf := make([]byte, PageSize)
+	db.meta.encode(buf)
+	pages := []walPage{{0, buf}}
+	for _, f := range db.pool.dirty() {
+		buf := make([]byte, PageSize)
+		f.node.encode(buf)
+		pages = append(pages, walPage{f.id, buf})
+	}
+	if err := db.wal.write(pages); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func walSize(t *testing.T, path string) int64 {
+	t.Helper()
+	st, err := os.Stat(path + ".wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Size()
+}
+
+func TestRecoverUnappliedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := open(t, path, 0)
+	for k := range int64(10) {
+		if err := db.Put(k, val(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logOnly(t, db, 100)
+	db.Close()
+	if walSize(t, path) == 0 {
+		t.Fatal("log is empty, nothing to recover")
+	}
+
+	db = open(t, path, 0)
+	defer db.Close()
+	if got, ok, err := db.Get(100); err != nil || !ok || !bytes.Equal(got, val(100)) {
+		t.Fatalf("Get(100) after recovery = %q, %v, %v", got, ok, err)
+	}
+	if db.Len() != 11 {
+		t.Fatalf("Len = %d, want 11", db.Len())
+	}
+	if walSize(t, path) != 0 {
+		t.Fatal("log not cleared after recovery")
+	}
+}
+
+func TestRecoverTornRecord(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		tear func(f *os.File, size int64) error
+	}{
+		{"truncated", func(f *os.File, size int64) error { return f.Truncate(size - PageSize/2) }},
+		{"bad checksum", func(f *os.File, size int64) error {
+			_, err := f.WriteAt([]byte{0xff, 0xff}, size-2)
+			return err
+		}},
+		{"header only", func(f *os.File, size int64) error { return f.Truncate(6) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db")
+			db := open(t, path, 0)
+			for k := range int64(10) {
+				if err := db.Put(k, val(k)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			logOnly(t, db, 100)
+			db.Close()
+
+			f, err := os.OpenFile(path+".wal", os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tc.tear(f, walSize(t, path)); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			db = open(t, path, 0)
+			defer db.Close()
+			if _, ok, err := db.Get(100); err != nil || ok {
+				t.Fatalf("Get(100) after torn log = %v, %v, want not found", ok, err)
+			}
+			if db.Len() != 10 {
+				t.Fatalf("Len = %d, want 10", db.Len())
+			}
+			for k := range int64(10) {
+				if got, ok, err := db.Get(k); err != nil || !ok || !bytes.Equal(got, val(k)) {
+					t.Fatalf("Get(%d) = %q, %v, %v", k, got, ok, err)
+				}
+			}
+			if walSize(t, path) != 0 {
+				t.Fatal("torn log not cleared")
+			}
+		})
+	}
+}
+
+// enough keys for several levels of internal pages, through a pool far
+// smaller than the tree so pages are read back from the file all the time.
+func TestReopenAfterSplits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := open(t, path, 4)
+	want := map[int64]bool{}
+	r := rand.New(rand.NewPCG(1, 2))
+	for range 20000 {
+		k := r.Int64N(50000)
+		if err := db.Put(k, val(k)); err != nil {
+			t.Fatal(err)
+		}
+		want[k] = true
+	}
+	for k := range want {
+		if k%3 == 0 {
+			if ok, err := db.Delete(k); err != nil || !ok {
+				t.Fatalf("Delete(%d) = %v, %v", k, ok, err)
+			}
+			delete(want, k)
+		}
+	}
+	if db.meta.pages < 100 {
+		t.Fatalf("only %d pages, the tree did not split much", db.meta.pages)
+	}
+	db.Close()
+
+	db = open(t, path, 4)
+	defer db.Close()
+	if db.Len() != len(want) {
+		t.Fatalf("Len = %d, want %d", db.Len(), len(want))
+	}
+	n, prev := 0, int64(-1)
+	for it := db.Iter(); it.Next(); {
+		e := it.Value()
+		if e.Key <= prev || !want[e.Key] || !bytes.Equal(e.Val, val(e.Key)) {
+			t.Fatalf("Iter gave %d (%q) after %d", e.Key, e.Val, prev)
+		}
+		prev = e.Key
+		n++
+	}
+	if n != len(want) {
+		t.Fatalf("Iter gave %d keys, want %d", n, len(want))
+	}
+	for k := range int64(50000) {
+		_, ok, err := db.Get(k)
+		if err != nil || ok != want[k] {
+			t.Fatalf("Get(%d) = %v, %v, want %v", k, ok, err, want[k])
+		}
+	}
+	n = 0
+	for it := db.Range(1000, 2000); it.Next(); n++ {
+		if k := it.Value().Key; k < 1000 || k > 2000 {
+			t.Fatalf("Range gave %d", k)
+		}
+	}
+	m := 0
+	for k := range want {
+		if k >= 1000 && k <= 2000 {
+			m++
+		}
+	}
+	if n != m {
+		t.Fatalf("Range gave %d keys, want %d", n, m)
+	}
+}
+
+func TestCorruptLeaf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := open(t, path, 0)
+	if err := db.Put(1, val(1)); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{9}, PageSize) // page 1, the root leaf
+	f.Close()
+
+	db = open(t, path, 0)
+	defer db.Close()
+	if err := db.Put(2, val(2)); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Put = %v, want ErrCorrupt", err)
+	}
+	if _, err := db.Delete(1); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Delete = %v, want ErrCorrupt", err)
+	}
+}
+
+// filling the DB and emptying it again, over and over, reuses the pages of
+// the emptied leaves instead of growing the file.
+func TestChurnKeepsFileSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := open(t, path, 8)
+	defer db.Close()
+	r := rand.New(rand.NewPCG(3, 4))
+	var size int64
+	for round := range 10 {
+		keys := r.Perm(3000)
+		for _, k := range keys {
+			if err := db.Put(int64(k), val(int64(k))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+		for _, k := range keys {
+			if ok, err := db.Delete(int64(k)); err != nil || !ok {
+				t.Fatalf("Delete(%d) = %v, %v", k, ok, err)
+			}
+		}
+		if it := db.Iter(); it.Next() || it.Err() != nil {
+			t.Fatalf("round %d: Iter gave %d (%v) in an empty DB", round, it.Value().Key, it.Err())
+		}
+		// empty again, every page but the meta and the root one is free
+		free := uint32(0)
+		for id := db.meta.free; id != 0; free++ {
+			n, err := db.pool.get(id)
+			if err != nil || !n.free {
+				t.Fatalf("round %d: page %d on the free list: %v", round, id, err)
+			}
+			id = n.next
+		}
+		if free != db.meta.pages-2 {
+			t.Fatalf("round %d: %d pages, %d of them free", round, db.meta.pages, free)
+		}
+
+		// the keys go in in a different order every round, so the splits
+		// (and the pages needed at the peak) differ a bit
+		st, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if round == 0 {
+			size = st.Size()
+		} else if st.Size() > size+size/10 {
+			t.Fatalf("round %d: file grew from %d to %d bytes", round, size, st.Size())
+		}
+	}
+	if db.Len() != 0 {
+		t.Fatalf("Len = %d", db.Len())
+	}
+}
+
+// deleting runs of keys empties leaves at the start, middle and end of the
+// chain; the rest stays walkable, also after a reopen.
+func TestDeleteEmptiesLeaves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := open(t, path, 4)
+	want := map[int64]bool{}
+	for k := range int64(5000) {
+		if err := db.Put(k, val(k)); err != nil {
+			t.Fatal(err)
+		}
+		want[k] = true
+	}
+	for k := range int64(5000) {
+		if k < 700 || k >= 2000 && k < 3500 || k >= 4800 {
+			if _, err := db.Delete(k); err != nil {
+				t.Fatal(err)
+			}
+			delete(want, k)
+		}
+	}
+	if db.meta.free == 0 {
+		t.Fatal("no page was freed")
+	}
+	pages := db.meta.pages
+	// and refilling takes the free pages
+	for k := range int64(2500) {
+		if k >= 2000 {
+			if err := db.Put(k, val(k)); err != nil {
+				t.Fatal(err)
+			}
+			want[k] = true
+		}
+	}
+	if db.meta.pages != pages {
+		t.Fatalf("refill went from %d to %d pages, with free ones left", pages, db.meta.pages)
+	}
+	db.Close()
+
+	db = open(t, path, 4)
+	defer db.Close()
+	n, prev := 0, int64(-1)
+	for it := db.Iter(); it.Next(); n++ {
+		e := it.Value()
+		if e.Key <= prev || !want[e.Key] {
+			t.Fatalf("Iter gave %d after %d", e.Key, prev)
+		}
+		prev = e.Key
+	}
+	if n != len(want) || db.Len() != len(want) {
+		t.Fatalf("Iter gave %d keys, Len %d, want %d", n, db.Len(), len(want))
+	}
+	for it := db.Range(1990, 3600); it.Next(); {
+		if k := it.Value().Key; !want[k] {
+			t.Fatalf("Range gave %d", k)
+		}
+	}
+	for k := range int64(5000) {
+		if _, ok, err := db.Get(k); err != nil || ok != want[k] {
+			t.Fatalf("Get(%d) = %v, %v, want %v", k, ok, err, want[k])
+		}
+	}
+}
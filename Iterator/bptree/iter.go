@@ -0,0 +1,144 @@
+package bptree
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrModified = errors.New("bptree: modified during iteration")
+
+type Entry struct {
+	Key int64
+	Val []byte
+}
+
+// Iter walks the keys in order by following the leaf links, one page read
+// (through the pool) per leaf. Like the TreeTraversal iterators it fails fast
+// if the DB is written to while it is in the middle of a walk; Reset starts
+// again from the DB as it is then.
+type Iter struct {
+	db      *DB
+	from    int64 // first key, when seeking
+	seek    bool
+	to      int64 // last key, when bounded
+	bounded bool
+
+	started bool
+	leaf    *node
+	next    pageID
+	idx     int
+	entry   Entry
+	mods    uint64
+	err     error
+}
+
+// Iter walks every key.
+func (db *DB) Iter() *Iter {
+	return &Iter{db: db}
+}
+
+// SeekGE walks from the first key >= key.
+func (db *DB) SeekGE(key int64) *Iter {
+	return &Iter{db: db, from: key, seek: true}
+}
+
+// Range walks the keys in [lo, hi].
+func (db *DB) Range(lo, hi int64) *Iter {
+	return &Iter{db: db, from: lo, seek: true, to: hi, bounded: true}
+}
+
+func (it *Iter) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	db := it.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if it.err = db.check(); it.err != nil {
+		return false
+	}
+	defer db.pool.trim()
+
+	if !it.started {
+		it.started = true
+		it.mods = db.mods
+		if it.err = it.start(); it.err != nil {
+			return false
+		}
+	} else if it.mods != db.mods {
+		it.err = ErrModified
+		return false
+	} else if it.leaf != nil {
+		it.idx++
+	}
+
+	// move on to the next leaf (maybe several, if some are empty)
+	for it.leaf != nil && it.idx >= len(it.leaf.keys) {
+		if it.next == 0 {
+			it.leaf = nil
+			break
+		}
+		leaf, err := db.pool.get(it.next)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.leaf, it.next, it.idx = leaf, leaf.next, 0
+	}
+	if it.leaf == nil || (it.bounded && it.leaf.keys[it.idx] > it.to) {
+		it.leaf = nil
+		return false
+	}
+	it.entry = Entry{it.leaf.keys[it.idx], append([]byte(nil), it.leaf.vals[it.idx]...)}
+	return true
+}
+
+// start finds the leaf and index of the first key, O(log n) page reads.
+func (it *Iter) start() error {
+	db := it.db
+	var id pageID
+	if it.seek {
+		_, _, leaf, err := db.descend(it.from)
+		if err != nil {
+			return err
+		}
+		id = leaf
+	} else {
+		// leftmost leaf
+		id = db.meta.root
+		for {
+			n, err := db.pool.get(id)
+			if err != nil {
+				return err
+			}
+			if n.leaf {
+				break
+			}
+			id = n.children[0]
+		}
+	}
+	leaf, err := db.pool.get(id)
+	if err != nil {
+		return err
+	}
+	it.leaf, it.next, it.idx = leaf, leaf.next, 0
+	if it.seek {
+		it.idx = sort.Search(len(leaf.keys), func(i int) bool { return leaf.keys[i] >= it.from })
+	}
+	return nil
+}
+
+// Value is a copy, it stays valid after the next call to Next.
+func (it *Iter) Value() Entry {
+	return it.entry
+}
+
+// Err is why the walk stopped early (a read error or ErrModified), nil if
+// it just ran out of keys.
+func (it *Iter) Err() error {
+	return it.err
+}
+
+func (it *Iter) Reset() {
+	it.started, it.leaf, it.err = false, nil, nil
+}
@@ -0,0 +1,167 @@
+package bptree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Every page on disk is PageSize bytes. Page 0 is the meta page, every other
+// page is one node of the tree, or free:
+//
+//	meta:     "BPT1" | root uint32 | pages uint32 | count uint64 | free uint32
+//	leaf:     1 | n uint16 | next uint32 | n * (key int64 | len uint16 | val)
+//	internal: 2 | n uint16 | (n+1) * child uint32 | n * key int64
+//	free:     3 | 0 uint16 | next uint32
+//
+// Leaves are linked left to right with next (0 = last leaf), which is what
+// the iterator follows. Free pages are linked the same way, from free in the
+// meta page (0 = none); files from before the free list have 0 there. An internal node with keys k0..kn-1 sends key k to
+// child i where i is the number of keys <= k.
+const (
+	PageSize    = 4096
+	MaxValueLen = 1024 // so that half a leaf always fits in a page
+
+	leafHeader     = 7
+	internalHeader = 3
+	maxInternalKey = (PageSize - internalHeader - 4) / 12
+)
+
+const (
+	pageLeaf     = 1
+	pageInternal = 2
+	pageFree     = 3
+)
+
+var (
+	metaMagic = []byte("BPT1")
+
+	ErrCorrupt       = errors.New("bptree: corrupt page")
+	ErrValueTooLarge = fmt.Errorf("bptree: value larger than %d bytes", MaxValueLen)
+)
+
+type pageID uint32
+
+type meta struct {
+	root  pageID
+	pages uint32 // pages in the file, including the meta page
+	count uint64 // number of keys
+	free  pageID // first page of the free list
+}
+
+// node is a page decoded, which is how pages live in the buffer pool.
+type node struct {
+	leaf     bool
+	free     bool // on the free list, next is the next free page
+	keys     []int64
+	vals     [][]byte // leaf only
+	children []pageID // internal only, len(keys)+1
+	next     pageID   // leaf and free only
+}
+
+func (n *node) encodedLen() int {
+	if !n.leaf {
+		return internalHeader + 4*len(n.children) + 8*len(n.keys)
+	}
+	l := leafHeader
+	for _, v := range n.vals {
+		l += 10 + len(v)
+	}
+	return l
+}
+
+func (n *node) encode(buf []byte) {
+	clear(buf)
+	binary.LittleEndian.PutUint16(buf[1:], uint16(len(n.keys)))
+	if n.free {
+		buf[0] = pageFree
+		binary.LittleEndian.PutUint32(buf[3:], uint32(n.next))
+		return
+	}
+	if n.leaf {
+		buf[0] = pageLeaf
+		binary.LittleEndian.PutUint32(buf[3:], uint32(n.next))
+		off := leafHeader
+		for i, k := range n.keys {
+			binary.LittleEndian.PutUint64(buf[off:], uint64(k))
+			binary.LittleEndian.PutUint16(buf[off+8:], uint16(len(n.vals[i])))
+			off += 10 + copy(buf[off+10:], n.vals[i])
+		}
+		return
+	}
+	buf[0] = pageInternal
+	off := internalHeader
+	for _, c := range n.children {
+		binary.LittleEndian.PutUint32(buf[off:], uint32(c))
+		off += 4
+	}
+	for _, k := range n.keys {
+		binary.LittleEndian.PutUint64(buf[off:], uint64(k))
+		off += 8
+	}
+}
+
+func decodeNode(buf []byte) (*node, error) {
+	n := &node{}
+	count := int(binary.LittleEndian.Uint16(buf[1:]))
+	switch buf[0] {
+	case pageLeaf:
+		n.leaf = true
+		n.next = pageID(binary.LittleEndian.Uint32(buf[3:]))
+		off := leafHeader
+		for i := 0; i < count; i++ {
+			if off+10 > len(buf) {
+				return nil, ErrCorrupt
+			}
+			k := int64(binary.LittleEndian.Uint64(buf[off:]))
+			l := int(binary.LittleEndian.Uint16(buf[off+8:]))
+			off += 10
+			if off+l > len(buf) {
+				return nil, ErrCorrupt
+			}
+			n.keys = append(n.keys, k)
+			n.vals = append(n.vals, append([]byte(nil), buf[off:off+l]...))
+			off += l
+		}
+	case pageInternal:
+		if count > maxInternalKey {
+			return nil, ErrCorrupt
+		}
+		off := internalHeader
+		for i := 0; i <= count; i++ {
+			n.children = append(n.children, pageID(binary.LittleEndian.Uint32(buf[off:])))
+			off += 4
+		}
+		for i := 0; i < count; i++ {
+			n.keys = append(n.keys, int64(binary.LittleEndian.Uint64(buf[off:])))
+			off += 8
+		}
+	case pageFree:
+		n.free = true
+		n.next = pageID(binary.LittleEndian.Uint32(buf[3:]))
+	default:
+		return nil, ErrCorrupt
+	}
+	return n, nil
+}
+
+func (m *meta) encode(buf []byte) {
+	clear(buf)
+	copy(buf, metaMagic)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(m.root))
+	binary.LittleEndian.PutUint32(buf[8:], m.pages)
+	binary.LittleEndian.PutUint64(buf[12:], m.count)
+	binary.LittleEndian.PutUint32(buf[20:], uint32(m.free))
+}
+
+func decodeMeta(buf []byte) (meta, error) {
+	if string(buf[:4]) != string(metaMagic) {
+		return meta{}, fmt.Errorf("%w: bad magic", ErrCorrupt)
+	}
+	return meta{
+		root:  pageID(binary.LittleEndian.Uint32(buf[4:])),
+		pages: binary.LittleEndian.Uint32(buf[8:]),
+		count: binary.LittleEndian.Uint64(buf[12:]),
+		free:  pageID(binary.LittleEndian.Uint32(buf[20:])),
+	}, nil
+}
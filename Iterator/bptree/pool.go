@@ -0,0 +1,98 @@
+package bptree
+
+import (
+	"container/list"
+	"io"
+	"os"
+)
+
+// pool is the buffer pool: the decoded pages which are in memory, up to
+// capacity of them, dropping the least recently used ones first. A dirty page
+// (changed, not yet on disk) is never dropped; dirty pages only exist during
+// a write, which ends with a commit making them all clean again. So pages
+// are only dropped in trim(), between operations, and nothing which an
+// operation holds on to goes away under it.
+type pool struct {
+	file     *os.File
+	capacity int
+	frames   map[pageID]*frame
+	lru      *list.List // of *frame, most recently used at the front
+}
+
+type frame struct {
+	id    pageID
+	node  *node
+	dirty bool
+	elem  *list.Element
+}
+
+func newPool(file *os.File, capacity int) *pool {
+	return &pool{file: file, capacity: capacity, frames: map[pageID]*frame{}, lru: list.New()}
+}
+
+func (p *pool) get(id pageID) (*node, error) {
+	if f, ok := p.frames[id]; ok {
+		p.lru.MoveToFront(f.elem)
+		return f.node, nil
+	}
+	buf := make([]byte, PageSize)
+	if _, err := p.file.ReadAt(buf, int64(id)*PageSize); err != nil && err != io.EOF {
+		return nil, err
+	}
+	n, err := decodeNode(buf)
+	if err != nil {
+		return nil, err
+	}
+	p.add(id, n, false)
+	return n, nil
+}
+
+// add puts a page into the pool, new pages come in dirty.
+func (p *pool) add(id pageID, n *node, dirty bool) {
+	f := &frame{id: id, node: n, dirty: dirty}
+	f.elem = p.lru.PushFront(f)
+	p.frames[id] = f
+}
+
+// put replaces the page id with n, dirty, whether it is in the pool or not.
+func (p *pool) put(id pageID, n *node) {
+	if f, ok := p.frames[id]; ok {
+		f.node, f.dirty = n, true
+		p.lru.MoveToFront(f.elem)
+		return
+	}
+	p.add(id, n, true)
+}
+
+func (p *pool) markDirty(id pageID) {
+	p.frames[id].dirty = true
+}
+
+func (p *pool) dirty() []*frame {
+	var fs []*frame
+	for _, f := range p.frames {
+		if f.dirty {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// trim drops clean pages until we are back to capacity.
+func (p *pool) trim() {
+	for e := p.lru.Back(); e != nil && len(p.frames) > p.capacity; {
+		f := e.Value.(*frame)
+		e = e.Prev()
+		if !f.dirty {
+			p.lru.Remove(f.elem)
+			delete(p.frames, f.id)
+		}
+	}
+}
+
+// reset forgets every page, after a failed commit what is in memory can't
+// be trusted any more.
+func (p *pool) reset() {
+	p.frames = map[pageID]*frame{}
+	p.lru.Init()
+}
@@ -0,0 +1,83 @@
+package bptree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// The write-ahead log makes a write all or nothing, even if the process
+// dies half way through updating the file. A commit:
+//
+//  1. appends every changed page, and a checksum over all of them, to the
+//     log and fsyncs it. Once that is done the write is durable.
+//  2. writes the pages to their place in the file and fsyncs it.
+//  3. empties the log.
+//
+// Dying in 1. leaves a log which fails the checksum, so it is thrown away
+// on the next Open and the file was never touched. Dying in 2. leaves a good
+// log, and Open writes it's pages again (doing it twice does no harm).
+//
+// A log record is: "WTX1" | n uint32 | n * (id uint32 | page) | crc32.
+
+var walMagic = []byte("WTX1")
+
+type wal struct {
+	f *os.File
+}
+
+type walPage struct {
+	id   pageID
+	data []byte
+}
+
+func (w *wal) write(pages []walPage) error {
+	var buf bytes.Buffer
+	buf.Write(walMagic)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(pages)))
+	for _, p := range pages {
+		binary.Write(&buf, binary.LittleEndian, uint32(p.id))
+		buf.Write(p.data)
+	}
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	if _, err := w.f.WriteAt(buf.Bytes(), 0); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+func (w *wal) clear() error {
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+// read gives the pages of a complete record in the log, nil if there is
+// none (empty log, or a torn write).
+func (w *wal) read() ([]walPage, error) {
+	data, err := io.ReadAll(io.NewSectionReader(w.f, 0, 1<<62))
+	if err != nil {
+		return nil, err
+	}
+	const header = 8
+	if len(data) < header+4 || !bytes.Equal(data[:4], walMagic) {
+		return nil, nil
+	}
+	n := int(binary.LittleEndian.Uint32(data[4:]))
+	end := header + n*(4+PageSize)
+	if n < 0 || end+4 > len(data) || end < header {
+		return nil, nil
+	}
+	if crc32.ChecksumIEEE(data[:end]) != binary.LittleEndian.Uint32(data[end:]) {
+		return nil, nil
+	}
+	pages := make([]walPage, n)
+	for i := range pages {
+		off := header + i*(4+PageSize)
+		pages[i] = walPage{pageID(binary.LittleEndian.Uint32(data[off:])), data[off+4 : off+4+PageSize]}
+	}
+	return pages, nil
+}
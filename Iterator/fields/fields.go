@@ -0,0 +1,117 @@
+// Package fields iterates over selected fields of any struct.
+//
+// Instead of hand writing a switch over the fields (like PersonNameIter used
+// to), the fields to visit are marked with a struct tag naming a group:
+//
+//	type Person struct {
+//		FirstName  string `iter:"name"`
+//		MiddleName string `iter:"name,omitempty"`
+//		LastName   string `iter:"name"`
+//	}
+//
+//	for it := fields.Of[string](&p, "name"); it.MoveNext(); {
+//		fmt.Println(it.Value())
+//	}
+//
+// Fields are visited in declaration order. A field tagged omitempty is
+// skipped while it holds the zero value of it's type, so adding a field is
+// just adding a tag, and every way of iterating which is built on this
+// (arrays, generators, iterator objects) skips exactly the same fields.
+package fields
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+type field struct {
+	index     int
+	omitEmpty bool
+}
+
+type cacheKey struct {
+	typ   reflect.Type
+	group string
+}
+
+// looking at the tags is done once per struct type and group.
+var cache sync.Map // cacheKey -> []field
+
+func fieldsOf(t reflect.Type, group string) []field {
+	key := cacheKey{t, group}
+	if fs, ok := cache.Load(key); ok {
+		return fs.([]field)
+	}
+	var fs []field
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("iter")
+		if !ok {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name != group {
+			continue
+		}
+		fs = append(fs, field{index: i, omitEmpty: opts == "omitempty"})
+	}
+	cache.Store(key, fs)
+	return fs
+}
+
+// Iter has the MoveNext/Value protocol, so it also works with the lazy
+// package.
+type Iter[T any] struct {
+	v       reflect.Value
+	fields  []field
+	current int
+}
+
+// Of iterates over the fields of the struct s points to (or s itself, if it
+// is a struct) which are tagged with group. It panics if s is not a struct
+// or a tagged field does not hold a T, the same as reflect would.
+func Of[T any](s any, group string) *Iter[T] {
+	v := reflect.Indirect(reflect.ValueOf(s))
+	if v.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("fields.Of: %T is not a struct", s))
+	}
+	fs := fieldsOf(v.Type(), group)
+	want := reflect.TypeFor[T]()
+	for _, f := range fs {
+		ft := v.Type().Field(f.index)
+		if !ft.IsExported() {
+			panic(fmt.Sprintf("fields.Of: field %s is not exported", ft.Name))
+		}
+		if !ft.Type.AssignableTo(want) {
+			panic(fmt.Sprintf("fields.Of: field %s is %s, not %s", ft.Name, ft.Type, want))
+		}
+	}
+	return &Iter[T]{v: v, fields: fs, current: -1}
+}
+
+func (it *Iter[T]) MoveNext() bool {
+	for it.current++; it.current < len(it.fields); it.current++ {
+		f := it.fields[it.current]
+		if !f.omitEmpty || !it.v.Field(f.index).IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *Iter[T]) Value() T {
+	if it.current < 0 || it.current >= len(it.fields) {
+		panic("illegal case")
+	}
+	return it.v.Field(it.fields[it.current].index).Interface().(T)
+}
+
+// All collects what is left of the iteration.
+func (it *Iter[T]) All() []T {
+	var out []T
+	for it.MoveNext() {
+		out = append(out, it.Value())
+	}
+	return out
+}
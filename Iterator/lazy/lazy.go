@@ -0,0 +1,230 @@
+// Package lazy builds pipelines on top of the iterator object approach
+// (MoveNext/Value) from Iterator/main.go.
+//
+// Nothing here materialises an intermediate slice: every combinator wraps the
+// iterator it is given and only pulls the next element from it when it's own
+// MoveNext is called. So e.g. Take(Filter(Map(it, f), g), 3) calls f and g
+// only as many times as needed to find 3 elements.
+package lazy
+
+// Iterator is the protocol of PersonNameIter: MoveNext advances and tells
+// whether there is an element, Value gives the element moved to.
+type Iterator[T any] interface {
+	MoveNext() bool
+	Value() T
+}
+
+// funcIter is what all the combinators return. next produces the following
+// element, or false once there are none left.
+type funcIter[T any] struct {
+	next func() (T, bool)
+	cur  T
+	done bool
+}
+
+func (it *funcIter[T]) MoveNext() bool {
+	if it.done {
+		return false
+	}
+	v, ok := it.next()
+	if !ok {
+		var zero T
+		it.cur, it.done = zero, true
+		return false
+	}
+	it.cur = v
+	return true
+}
+
+func (it *funcIter[T]) Value() T {
+	return it.cur
+}
+
+func newIter[T any](next func() (T, bool)) Iterator[T] {
+	return &funcIter[T]{next: next}
+}
+
+// Sources.
+
+func FromSlice[T any](s []T) Iterator[T] {
+	i := 0
+	return newIter(func() (T, bool) {
+		if i >= len(s) {
+			var zero T
+			return zero, false
+		}
+		i++
+		return s[i-1], true
+	})
+}
+
+// FromChan reads until the channel is closed, e.g. Person.NamesGenerator.
+func FromChan[T any](ch <-chan T) Iterator[T] {
+	return newIter(func() (T, bool) {
+		v, ok := <-ch
+		return v, ok
+	})
+}
+
+// FromNext adapts iterators which spell MoveNext as Next, like NodeIter:
+//
+//	it := tree.Iter()
+//	lazy.FromNext(it.Next, it.Value)
+func FromNext[T any](next func() bool, value func() T) Iterator[T] {
+	return newIter(func() (T, bool) {
+		if !next() {
+			var zero T
+			return zero, false
+		}
+		return value(), true
+	})
+}
+
+// Combinators.
+
+func Map[T, U any](it Iterator[T], f func(T) U) Iterator[U] {
+	return newIter(func() (U, bool) {
+		if !it.MoveNext() {
+			var zero U
+			return zero, false
+		}
+		return f(it.Value()), true
+	})
+}
+
+func Filter[T any](it Iterator[T], keep func(T) bool) Iterator[T] {
+	return newIter(func() (T, bool) {
+		for it.MoveNext() {
+			if v := it.Value(); keep(v) {
+				return v, true
+			}
+		}
+		var zero T
+		return zero, false
+	})
+}
+
+// Take stops after n elements.
+func Take[T any](it Iterator[T], n int) Iterator[T] {
+	return newIter(func() (T, bool) {
+		if n <= 0 || !it.MoveNext() {
+			var zero T
+			return zero, false
+		}
+		n--
+		return it.Value(), true
+	})
+}
+
+// TakeWhile stops at the first element for which ok is false (that element
+// is consumed from it but not yielded).
+func TakeWhile[T any](it Iterator[T], ok func(T) bool) Iterator[T] {
+	return newIter(func() (T, bool) {
+		if it.MoveNext() {
+			if v := it.Value(); ok(v) {
+				return v, true
+			}
+		}
+		var zero T
+		return zero, false
+	})
+}
+
+// Skip drops the first n elements.
+func Skip[T any](it Iterator[T], n int) Iterator[T] {
+	return newIter(func() (T, bool) {
+		for ; n > 0; n-- {
+			if !it.MoveNext() {
+				var zero T
+				return zero, false
+			}
+		}
+		if !it.MoveNext() {
+			var zero T
+			return zero, false
+		}
+		return it.Value(), true
+	})
+}
+
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+// Zip pairs up the elements of a and b, stopping when either runs out.
+func Zip[A, B any](a Iterator[A], b Iterator[B]) Iterator[Pair[A, B]] {
+	return newIter(func() (Pair[A, B], bool) {
+		if !a.MoveNext() || !b.MoveNext() {
+			return Pair[A, B]{}, false
+		}
+		return Pair[A, B]{a.Value(), b.Value()}, true
+	})
+}
+
+// Chain gives all of the first iterator, then all of the second and so on.
+func Chain[T any](its ...Iterator[T]) Iterator[T] {
+	return newIter(func() (T, bool) {
+		for len(its) > 0 {
+			if its[0].MoveNext() {
+				return its[0].Value(), true
+			}
+			its = its[1:]
+		}
+		var zero T
+		return zero, false
+	})
+}
+
+// Window gives every run of n consecutive elements, sliding by one, so
+// [1 2 3 4] with n = 2 gives [1 2] [2 3] [3 4]. Each window is a new slice
+// which the caller may keep.
+func Window[T any](it Iterator[T], n int) Iterator[[]T] {
+	var win []T
+	return newIter(func() ([]T, bool) {
+		if n <= 0 {
+			return nil, false
+		}
+		if len(win) == n {
+			win = win[1:]
+		}
+		for len(win) < n {
+			if !it.MoveNext() {
+				return nil, false
+			}
+			win = append(win, it.Value())
+		}
+		out := make([]T, n)
+		copy(out, win)
+		return out, true
+	})
+}
+
+// Chunk cuts the elements into slices of n, the last one may be shorter.
+func Chunk[T any](it Iterator[T], n int) Iterator[[]T] {
+	return newIter(func() ([]T, bool) {
+		var chunk []T
+		for len(chunk) < n && it.MoveNext() {
+			chunk = append(chunk, it.Value())
+		}
+		return chunk, len(chunk) > 0
+	})
+}
+
+// Terminal operations, these are the ones which actually run the pipeline.
+
+func Reduce[T, R any](it Iterator[T], init R, f func(R, T) R) R {
+	acc := init
+	for it.MoveNext() {
+		acc = f(acc, it.Value())
+	}
+	return acc
+}
+
+func Collect[T any](it Iterator[T]) []T {
+	var out []T
+	for it.MoveNext() {
+		out = append(out, it.Value())
+	}
+	return out
+}
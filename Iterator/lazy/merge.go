@@ -0,0 +1,85 @@
+package lazy
+
+import "container/heap"
+
+// Merge combines iterators which are each sorted (by cmp) into one sorted
+// iterator, e.g. one NodeIter per shard, a sorted slice and a channel.
+// It is lazy like the rest: only the head of every source is held, in a
+// heap, so the cost is O(log k) per element for k sources and nothing is
+// concatenated or sorted up front.
+//
+// Elements which compare equal come out in the order of the sources given.
+func Merge[T any](cmp func(a, b T) int, its ...Iterator[T]) Iterator[T] {
+	h := &mergeHeap[T]{cmp: cmp}
+	started := false
+	return newIter(func() (T, bool) {
+		if !started {
+			started = true
+			for i, it := range its {
+				if it.MoveNext() {
+					h.items = append(h.items, mergeItem[T]{it.Value(), i})
+				}
+			}
+			heap.Init(h)
+		}
+		if h.Len() == 0 {
+			var zero T
+			return zero, false
+		}
+		top := h.items[0]
+		// refill from the source the smallest came from
+		if src := its[top.src]; src.MoveNext() {
+			h.items[0].val = src.Value()
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+		return top.val, true
+	})
+}
+
+// MergeDistinct is Merge, but a value equal to the one just yielded is
+// dropped, so the result has every value once.
+func MergeDistinct[T any](cmp func(a, b T) int, its ...Iterator[T]) Iterator[T] {
+	merged := Merge(cmp, its...)
+	var last T
+	first := true
+	return Filter(merged, func(v T) bool {
+		if !first && cmp(last, v) == 0 {
+			return false
+		}
+		first, last = false, v
+		return true
+	})
+}
+
+type mergeItem[T any] struct {
+	val T
+	src int // which iterator it came from
+}
+
+// mergeHeap implements heap.Interface.
+type mergeHeap[T any] struct {
+	items []mergeItem[T]
+	cmp   func(a, b T) int
+}
+
+func (h *mergeHeap[T]) Len() int { return len(h.items) }
+
+func (h *mergeHeap[T]) Less(i, j int) bool {
+	if c := h.cmp(h.items[i].val, h.items[j].val); c != 0 {
+		return c < 0
+	}
+	return h.items[i].src < h.items[j].src
+}
+
+func (h *mergeHeap[T]) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *mergeHeap[T]) Push(x any) { h.items = append(h.items, x.(mergeItem[T])) }
+
+func (h *mergeHeap[T]) Pop() any {
+	old := h.items
+	x := old[len(old)-1]
+	h.items = old[:len(old)-1]
+	return x
+}
@@ -1,6 +1,14 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+
+	"github.com/riteshharjani/design-pattens-go/Iterator/fields"
+	"github.com/riteshharjani/design-pattens-go/Iterator/lazy"
+)
 
 // The iterator pattern is all about traversing the data structures.
 
@@ -17,15 +25,20 @@ import "fmt"
 // e.g.
 // To iterate meaning let's go over a selection/every element.
 
+// The tags say which fields are "names" (and that an empty middle name is
+// not a name), so all the approaches below go over the same fields w/o
+// hard coding them, see the fields package.
 type Person struct {
-	FirstName, MiddleName, LastName string
+	FirstName  string `iter:"name"`
+	MiddleName string `iter:"name,omitempty"`
+	LastName   string `iter:"name"`
 }
 
 // what if someone wants to go over every single name in the Person
-// one approach is to everything in an array and since array has an built-in
-// iterator.
-func (p *Person) Names() [3]string {
-	return [3]string{p.FirstName, p.MiddleName, p.LastName}
+// one approach is to everything in an array (a slice, as the number of names
+// is not fixed) and since it has an built-in iterator.
+func (p *Person) Names() []string {
+	return fields.Of[string](p, "name").All()
 }
 
 // 2nd approach
@@ -35,11 +48,28 @@ func (p *Person) NamesGenerator() <-chan string {
 	out := make(chan string)
 	go func() {
 		defer close(out)
-		out <- p.FirstName
-		if len(p.MiddleName) > 0 {
-			out <- p.MiddleName
+		for it := fields.Of[string](p, "name"); it.MoveNext(); {
+			out <- it.Value()
+		}
+	}()
+	return out
+}
+
+// Problem with the generator: if whoever ranges over the channel breaks out
+// early, the go routine stays blocked on `out <-` forever (a leak). So the
+// cancellable version also watches a context, and the consumer cancels it
+// when it is done (typically with a defer).
+func (p *Person) NamesGeneratorCtx(ctx context.Context) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		for it := fields.Of[string](p, "name"); it.MoveNext(); {
+			select {
+			case out <- it.Value():
+			case <-ctx.Done():
+				return
+			}
 		}
-		out <- p.LastName
 	}()
 	return out
 }
@@ -49,33 +79,40 @@ func (p *Person) NamesGenerator() <-chan string {
 // we need to make newtype of struct which will have a pointer to the struct which we want to iterate upon
 // and a current value to know where we are while during iteration.
 // Pointer is needed to avoid copying the data everytime.
+//
+// The where-are-we part used to be a `current int` and a switch over the
+// three fields here, now it is the generic field iterator which does that.
 type PersonNameIter struct {
-	person  *Person
-	current int
+	fields *fields.Iter[string] // holds the pointer to the person
 }
 
 func NewPersonNameIter(person *Person) *PersonNameIter {
-	return &PersonNameIter{person, -1}
+	return &PersonNameIter{fields.Of[string](person, "name")}
 }
 
 // move the iterator forward and checks provides a end condition on when
 // to break the iteration.
 func (p *PersonNameIter) MoveNext() bool {
-	p.current++
-	return p.current < 3
+	return p.fields.MoveNext()
 }
 
 // This will return the value of the current iterator.
 func (p *PersonNameIter) Value() string {
-	switch p.current {
-	case 0:
-		return p.person.FirstName
-	case 1:
-		return p.person.MiddleName
-	case 2:
-		return p.person.LastName
+	return p.fields.Value()
+}
+
+// 4th approach (go 1.23+) - range over a function.
+// The function calls yield for every element and stops as soon as yield
+// returns false (i.e. the loop did a break). No go routine, no channel,
+// nothing to leak, and it can sit on top of the iterator object above.
+func (p *Person) All() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for it := NewPersonNameIter(p); it.MoveNext(); {
+			if !yield(it.Value()) {
+				return
+			}
+		}
 	}
-	panic("illegal case")
 }
 
 func main() {
@@ -96,6 +133,30 @@ func main() {
 		fmt.Println(it.Value())
 	}
 
+	// method 4 - range over func
+	for name := range p.All() {
+		fmt.Println(name)
+	}
+
+	// breaking out early, the generator go routine gets cancelled instead
+	// of leaking.
+	ctx, cancel := context.WithCancel(context.Background())
+	for name := range p.NamesGeneratorCtx(ctx) {
+		fmt.Println(name)
+		break
+	}
+	cancel()
+
+	// since the iterator object has a fixed protocol (MoveNext/Value) we can
+	// build pipelines on top of it, see the lazy package.
+	long := lazy.Filter(NewPersonNameIter(&p), func(s string) bool { return len(s) > 4 })
+	fmt.Println(lazy.Collect(lazy.Map(long, strings.ToUpper)))
+
+	// w/o a middle name every approach skips it the same way.
+	n := Person{"Isaac", "", "Newton"}
+	fmt.Println(n.Names(), lazy.Collect(lazy.FromChan(n.NamesGenerator())),
+		lazy.Collect[string](NewPersonNameIter(&n)))
+
 	/* Output of above
 	* Alexander
 	* Graham
@@ -106,6 +167,12 @@ func main() {
 	* Alexander
 	* Graham
 	* Bell
+	* Alexander
+	* Graham
+	* Bell
+	* Alexander
+	* [ALEXANDER GRAHAM]
+	* [Isaac Newton] [Isaac Newton] [Isaac Newton]
 	 */
 
 }
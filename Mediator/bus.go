@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/riteshharjani/design-pattens-go/Mediator/eventbus"
+)
+
+// A Chatroom can also be on an eventbus (see eventbus/), so that things
+// which are not people (a pager, a deploy bot, a dashboard) can listen in
+// and talk to it w/o a Person, and w/o knowing about Chatroom at all. With
+// room being the name of the room ("lobby" if it has none) the room
+//
+//	publishes  chat.<room>.<kind>    every Message it sends, in order
+//	answers    chat.<room>.say       a Message (chat or private) to say, the
+//	                                 reply is struct{} or why it was not said
+//	answers    chat.<room>.members   struct{}, with Members
+//
+// So eventbus.Subscribe(bus, "chat.*.join", ...) hears every join in every
+// room on the bus.
+
+var ErrNotSayable = errors.New("only chat and private msgs can be said")
+
+func roomTopic(name string) string {
+	if name == "" {
+		name = "lobby"
+	}
+	return "chat." + name
+}
+
+// WithBus puts the room on b. Close takes it off again.
+func (c *Chatroom) WithBus(b *eventbus.Bus) *Chatroom {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bus = b
+	topic := roomTopic(c.Name)
+	c.busSubs = append(c.busSubs,
+		eventbus.Respond(b, topic+".say", func(_ string, m Message) (struct{}, error) {
+			if m.Kind != Chat && m.Kind != Private {
+				return struct{}{}, ErrNotSayable
+			}
+			if m.Kind == Private && len(m.To) == 1 {
+				return struct{}{}, c.Message(m.Sender, m.To[0], m.Text)
+			}
+			return struct{}{}, c.submit(Message{Kind: m.Kind, Sender: m.Sender, To: m.To, Text: m.Text})
+		}),
+		eventbus.Respond(b, topic+".members", func(string, struct{}) ([]string, error) {
+			return c.Members(), nil
+		}),
+	)
+	return c
+}
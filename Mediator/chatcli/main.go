@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/riteshharjani/design-pattens-go/Mediator/websocket"
+)
+
+// A small client for the chat Frontend (see ../frontend.go), e.g.
+//
+//	go run ./chatcli -addr localhost:7000 -name john
+//	go run ./chatcli -ws ws://localhost:8000/ -name jane
+//
+// Whatever you type is said in the room, except for
+//
+//	/msg <who> <text>      private msg
+//	/history [before] [n]  what was said before, see HISTORY
+//	/leave                 leave, but stay connected
+//	/join <name>           join (again)
+//	/away, /back           PRESENCE away or online
+//	/read <id>             a read receipt, see READ
+//	/quit                  leave and exit
+//	/raw <command>         a protocol command as is, e.g. "/raw FORMAT json"
+//
+// Other /commands, like /me or /who, are said too and it is up to the room
+// what to make of them.
+
+type conn interface {
+	ReadLine() (string, error)
+	WriteLine(string) error
+}
+
+type tcp struct {
+	net.Conn
+	sc *bufio.Scanner
+}
+
+func (c tcp) ReadLine() (string, error) {
+	if c.sc.Scan() {
+		return c.sc.Text(), nil
+	}
+	if c.sc.Err() != nil {
+		return "", c.sc.Err()
+	}
+	return "", net.ErrClosed
+}
+
+func (c tcp) WriteLine(s string) error {
+	_, err := fmt.Fprintln(c, s)
+	return err
+}
+
+type ws struct {
+	*websocket.Conn
+}
+
+func (c ws) ReadLine() (string, error) { return c.ReadMessage() }
+func (c ws) WriteLine(s string) error  { return c.WriteMessage(s) }
+
+// command turns what the user typed into a protocol line.
+func command(line string) string {
+	switch {
+	case strings.HasPrefix(line, "/raw "):
+		return line[len("/raw "):]
+	case line == "/leave":
+		return "LEAVE"
+	case line == "/quit":
+		return "QUIT"
+	case strings.HasPrefix(line, "/msg "):
+		return "MSG " + line[len("/msg "):]
+	case line == "/history" || strings.HasPrefix(line, "/history "):
+		return "HISTORY" + line[len("/history"):]
+	case line == "/away":
+		return "PRESENCE away"
+	case line == "/back":
+		return "PRESENCE online"
+	case strings.HasPrefix(line, "/read "):
+		return "READ " + line[len("/read "):]
+	case strings.HasPrefix(line, "/join "):
+		return "JOIN " + line[len("/join "):]
+	}
+	return "SAY " + line
+}
+
+func main() {
+	addr := flag.String("addr", "", "host:port of the tcp front-end")
+	url := flag.String("ws", "", "ws:// url of the websocket front-end")
+	name := flag.String("name", "", "join as name straight away")
+	flag.Parse()
+
+	var c conn
+	switch {
+	case *url != "":
+		wc, err := websocket.Dial(*url)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer wc.Close()
+		c = ws{wc}
+	case *addr != "":
+		nc, err := net.Dial("tcp", *addr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer nc.Close()
+		c = tcp{nc, bufio.NewScanner(nc)}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// whatever the server sends is printed as it comes, and when it hangs up
+	// we are done.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			fmt.Println(line)
+		}
+	}()
+
+	if *name != "" {
+		c.WriteLine("JOIN " + *name)
+	}
+	in := bufio.NewScanner(os.Stdin)
+	for in.Scan() {
+		line := strings.TrimSpace(in.Text())
+		if line == "" {
+			continue
+		}
+		cmd := command(line)
+		if c.WriteLine(cmd) != nil || cmd == "QUIT" {
+			break
+		}
+	}
+	c.WriteLine("QUIT") // at EOF on stdin, else the server already hung up
+	<-done
+}
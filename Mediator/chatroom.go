@@ -0,0 +1,456 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/riteshharjani/design-pattens-go/Mediator/eventbus"
+)
+
+// let's define room now.
+
+var (
+	ErrNameTaken    = errors.New("name already taken in this room")
+	ErrBanned       = errors.New("banned from this room")
+	ErrNoSuchPerson = errors.New("no such person in the room")
+	ErrRoomClosed   = errors.New("room closed")
+)
+
+// A Chatroom is safe for concurrent use: people can join, say things and
+// leave from as many go routines as they like. What everyone can count on is
+// that the msgs of one sender reach every member in the order they were said.
+//
+// It works like this. Everything in the room is guarded by mu, but nothing is
+// delivered while mu is held. A msg gets it's ID under mu and is queued in
+// out, and whoever unlocks mu then delivers the queue (see unlock). Only one
+// go routine at a time does that, so the msgs go out in the order of their
+// IDs, and a Receive which says something right back (a bot, say) does not
+// deadlock, it's msg is just queued after the one it got.
+type Chatroom struct {
+	// Name and topic only matter once there are many rooms, see server.go.
+	Name  string
+	topic string
+
+	mu       sync.Mutex
+	out      []parcel // to be delivered once mu is let go
+	flushing bool     // someone is delivering out already
+	closed   bool
+
+	people []*Person // in the order they joined, that is the order msgs go out
+	// and the map using the key as the name, so the search is O(1). Names
+	// are unique in a room.
+	byName map[string]*Person
+	banned map[string]bool
+
+	// how a msg gets to each person, see delivery.go. nil is SyncDelivery,
+	// so a zero Chatroom{} works just like before.
+	delivery Delivery
+
+	// what people say goes through these before it is sent, see
+	// interceptor.go.
+	interceptors []Interceptor
+
+	// what was said, and how much of it a newcomer gets, see history.go.
+	history Store
+	replay  int
+	lastID  uint64 // of the last msg sent in the room
+
+	// who read the last few msgs, see presence.go.
+	receipts map[uint64]*receipt
+	tracked  []uint64 // the IDs in receipts, oldest first
+
+	// the eventbus the room is on, if any, see bus.go.
+	bus     *eventbus.Bus
+	busSubs []*eventbus.Subscription
+}
+
+// a parcel is one msg for p, or with remove the end of the msgs for p, or
+// (with no p) a msg to publish on bus.
+type parcel struct {
+	p      *Person
+	m      Message
+	remove bool
+	bus    *eventbus.Bus
+}
+
+func NewChatroom(d Delivery) *Chatroom {
+	return &Chatroom{delivery: d}
+}
+
+// WithHistory keeps everything broadcast in the room in s, and replays the
+// last replay msgs of it to whoever joins. The room closes s on Close.
+func (c *Chatroom) WithHistory(s Store, replay int) *Chatroom {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.history, c.replay = s, replay
+	// IDs carry on from what was said before a restart
+	if last, err := s.Page(0, 1); err == nil && len(last) > 0 {
+		c.lastID = max(c.lastID, last[0].ID)
+	}
+	return c
+}
+
+// History gives a page of what was said in the room, see Store.Page.
+func (c *Chatroom) History(before uint64, n int) ([]Message, error) {
+	c.mu.Lock()
+	s := c.history
+	c.mu.Unlock()
+	if s == nil {
+		return nil, nil
+	}
+	return s.Page(before, n)
+}
+
+func (c *Chatroom) deliverer() Delivery {
+	if c.delivery == nil {
+		return SyncDelivery{}
+	}
+	return c.delivery
+}
+
+// unlock lets go of mu, after delivering what was queued in out unless
+// someone else is doing that already (that one gets to the new ones too). Every
+// method which queues something unlocks with this rather than mu.Unlock.
+func (c *Chatroom) unlock() {
+	if c.flushing {
+		c.mu.Unlock()
+		return
+	}
+	c.flushing = true
+	for len(c.out) > 0 {
+		out := c.out
+		c.out = nil
+		c.mu.Unlock()
+
+		var gone []*Person
+		for _, pc := range out {
+			if pc.bus != nil {
+				eventbus.Publish(pc.bus, roomTopic(pc.m.Room)+"."+pc.m.Kind.String(), pc.m)
+				continue
+			}
+			if pc.remove {
+				c.deliverer().Remove(pc.p)
+				continue
+			}
+			// a closed delivery means the room is closing, not that p is
+			// too slow.
+			if err := c.deliverer().Deliver(pc.p, pc.m); err != nil && err != ErrClosed {
+				gone = append(gone, pc.p)
+			}
+		}
+
+		c.mu.Lock()
+		c.disconnect(gone)
+	}
+	c.flushing = false
+	c.mu.Unlock()
+}
+
+// send stamps m with the next ID (a signal gets none), the room and the
+// time, and queues it for m.To, or if that is empty for everyone in the room
+// but the sender.
+func (c *Chatroom) send(m Message) {
+	m.Room, m.Time = c.Name, time.Now()
+	if !m.Kind.signal() {
+		c.lastID++
+		m.ID = c.lastID
+		c.track(m) // for the read receipts, see presence.go
+	}
+	// only what everyone in the room got is kept, not private msgs or the
+	// answer to one person (like /who).
+	if c.history != nil && len(m.To) == 0 && !m.Kind.signal() {
+		// the history is best effort, the msg goes out even if it can't be
+		// kept.
+		c.history.Append(m)
+	}
+	if c.bus != nil {
+		c.out = append(c.out, parcel{m: m, bus: c.bus})
+	}
+	if len(m.To) > 0 {
+		for _, name := range m.To {
+			if p, ok := c.byName[name]; ok {
+				c.out = append(c.out, parcel{p: p, m: m})
+			}
+		}
+		return
+	}
+	for _, p := range c.people {
+		if p.Name != m.Sender {
+			c.out = append(c.out, parcel{p: p, m: m})
+		}
+	}
+}
+
+// announce is the room itself saying something.
+func (c *Chatroom) announce(kind Kind, text string, meta map[string]string) {
+	c.send(Message{Kind: kind, Text: text, Meta: meta})
+}
+
+// let's define a way of Broadcasting. What people say goes through the
+// interceptors first (see interceptor.go), which may turn it down.
+func (c *Chatroom) Broadcast(source, message string) error {
+	return c.submit(Message{Kind: Chat, Sender: source, Text: message})
+}
+
+// let's define a way of messaging one other
+func (c *Chatroom) Message(src, dst, msg string) error {
+	if c.person(dst) == nil {
+		return ErrNoSuchPerson
+	}
+	return c.submit(Message{Kind: Private, Sender: src, To: []string{dst}, Text: msg})
+}
+
+// person is whoever is called name in the room, nil if nobody.
+func (c *Chatroom) person(name string) *Person {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.byName[name]
+}
+
+func (c *Chatroom) Join(p *Person) error {
+	// p's name can't change until p is in, see rename. renaming is let go
+	// before the join is delivered, as that might lead to a rename.
+	renaming.RLock()
+	c.mu.Lock()
+	err := c.join(p)
+	renaming.RUnlock()
+	c.unlock()
+	return err
+}
+
+func (c *Chatroom) join(p *Person) error {
+	switch {
+	case c.closed:
+		return ErrRoomClosed
+	case c.banned[p.Name]:
+		return ErrBanned
+	case c.byName[p.Name] != nil:
+		return ErrNameTaken
+	}
+	// what was said before comes first, so it is not mixed up with the
+	// msgs after the join.
+	if c.replay > 0 {
+		if old, err := c.history.Page(0, c.replay); err == nil {
+			for _, m := range old {
+				c.out = append(c.out, parcel{p: p, m: m.WithMeta("replay", "true")})
+			}
+		}
+	}
+	// let's say when anyone joins then we do a broadcast to everyone
+	joinMsg := p.Name + " joins the chat"
+	c.announce(Join, joinMsg, map[string]string{"who": p.Name})
+	// p is in the room (and it is p's current one) before anything p
+	// could say to it, since Join returns only after this.
+	p.joined(c)
+	c.people = append(c.people, p)
+	if c.byName == nil {
+		c.byName = map[string]*Person{}
+	}
+	c.byName[p.Name] = p
+	return nil
+}
+
+// Leave takes p out of the room, and reports whether p was in it.
+func (c *Chatroom) Leave(p *Person) bool {
+	c.mu.Lock()
+	defer c.unlock()
+	if !slices.Contains(c.people, p) {
+		return false
+	}
+	name := p.Name // can't change while p is in the room and mu is held
+	c.remove(p)
+	c.announce(Leave, name+" leaves the chat", map[string]string{"who": name})
+	return true
+}
+
+// Kick throws name out of the room, who can come back though. by is just
+// who did it, for the msg everyone gets (the kicked one too).
+func (c *Chatroom) Kick(by, name string) error {
+	c.mu.Lock()
+	defer c.unlock()
+	p, ok := c.byName[name]
+	if !ok {
+		return ErrNoSuchPerson
+	}
+	c.announce(Leave, name+" was kicked by "+by,
+		map[string]string{"who": name, "reason": "kicked", "by": by})
+	c.remove(p)
+	return nil
+}
+
+// Ban kicks name (if in the room) and keeps that name from joining again
+// until Unban.
+func (c *Chatroom) Ban(by, name string) {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.banned == nil {
+		c.banned = map[string]bool{}
+	}
+	c.banned[name] = true
+	p, in := c.byName[name]
+	kind := System // nobody leaves if name is not here
+	if in {
+		kind = Leave
+	}
+	c.announce(kind, name+" was banned by "+by,
+		map[string]string{"who": name, "reason": "banned", "by": by})
+	if in {
+		c.remove(p)
+	}
+}
+
+func (c *Chatroom) Unban(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.banned, name)
+}
+
+// renaming is held by a rename, the only thing which locks more than one
+// room at once (so two of them must not run together), and read locked by a
+// Join while it looks at the name of someone not in the room yet.
+var renaming sync.RWMutex
+
+// rename changes the name of p, in all the rooms p is in, as long as
+// none of them has someone called that already or has banned it.
+func rename(p *Person, name string) error {
+	renaming.Lock()
+	rooms := p.roomList()
+	for _, c := range rooms {
+		c.mu.Lock()
+	}
+	err := renameLocked(p, name, rooms)
+	for _, c := range rooms {
+		c.mu.Unlock()
+	}
+	renaming.Unlock()
+	// and now deliver the news, w/o holding any other room.
+	for _, c := range rooms {
+		c.mu.Lock()
+		c.unlock()
+	}
+	return err
+}
+
+func renameLocked(p *Person, name string, rooms []*Chatroom) error {
+	for _, c := range rooms {
+		switch {
+		case c.banned[name]:
+			return ErrBanned
+		case c.byName[name] != nil:
+			return ErrNameTaken
+		}
+	}
+	old := p.rename(name)
+	for _, c := range rooms {
+		delete(c.byName, old)
+		c.byName[name] = p
+		c.announce(System, old+" is now known as "+name,
+			map[string]string{"who": name, "was": old})
+	}
+	return nil
+}
+
+// remove takes p out. The delivery lets go of p only after what is
+// already queued for p, e.g. the news of being kicked.
+func (c *Chatroom) remove(p *Person) bool {
+	i := slices.Index(c.people, p)
+	if i < 0 {
+		return false
+	}
+	delete(c.byName, p.Name)
+	c.people = slices.Delete(c.people, i, i+1)
+	c.out = append(c.out, parcel{p: p, remove: true})
+	p.left(c)
+	return true
+}
+
+// disconnect throws out the people the delivery gave up on (only the
+// Disconnect policy does that) and tells everyone else about it.
+func (c *Chatroom) disconnect(gone []*Person) {
+	for _, p := range gone {
+		if !slices.Contains(c.people, p) {
+			continue // already thrown out
+		}
+		name := p.Name
+		c.remove(p)
+		c.announce(Leave, name+" was disconnected (too slow)",
+			map[string]string{"who": name, "reason": "disconnected"})
+	}
+}
+
+func (c *Chatroom) Topic() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.topic
+}
+
+func (c *Chatroom) SetTopic(by, topic string) {
+	c.mu.Lock()
+	defer c.unlock()
+	c.topic = topic
+	c.announce(System, by+" sets the topic to: "+topic,
+		map[string]string{"topic": topic, "by": by})
+}
+
+// Members gives the names of the people in the room, in the order they
+// joined.
+func (c *Chatroom) Members() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.names()
+}
+
+// names of the people, with mu held: a Name can't change then, as rename
+// holds the mu of every room the person is in.
+func (c *Chatroom) names() []string {
+	names := make([]string, len(c.people))
+	for i, p := range c.people {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// closeIfEmpty closes the room for joins if nobody is in it, and reports
+// whether it did, see Server.Leave. It gives who is banned, for the next room
+// of the same name.
+func (c *Chatroom) closeIfEmpty() (banned map[string]bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || len(c.people) > 0 {
+		return nil, false
+	}
+	c.closed = true
+	return maps.Clone(c.banned), true
+}
+
+func (c *Chatroom) String() string {
+	s := "#" + c.Name + " (" + strings.Join(c.Members(), ", ") + ")"
+	if t := c.Topic(); t != "" {
+		s += ": " + t
+	}
+	return s
+}
+
+// Close waits until everything which was said has been delivered, and stops
+// the delivery go routines (if any). The history store is closed too, the
+// room goes off the bus, and nobody can join any more.
+func (c *Chatroom) Close() {
+	c.mu.Lock()
+	c.closed = true
+	subs := c.busSubs
+	c.busSubs = nil
+	c.mu.Unlock()
+	for _, s := range subs {
+		s.Unsubscribe()
+	}
+	c.deliverer().Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.history != nil {
+		c.history.Close()
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// hearing keeps what one person heard of each sender, by a tag in the text
+// which stays the same when the sender changes name.
+type hearing struct {
+	mu    sync.Mutex
+	last  map[string]int
+	count map[string]int
+	bad   []string
+}
+
+func listen(p *Person) *hearing {
+	h := &hearing{last: map[string]int{}, count: map[string]int{}}
+	p.Out = io.Discard
+	p.OnReceive = func(m Message) {
+		if m.Kind != Chat {
+			return
+		}
+		tag, num, _ := strings.Cut(m.Text, " ")
+		n, err := strconv.Atoi(num)
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if err != nil || n <= h.last[tag] {
+			h.bad = append(h.bad, fmt.Sprintf("%q from %s after %d", m.Text, m.Sender, h.last[tag]))
+		}
+		h.last[tag] = n
+		h.count[tag]++
+	}
+	return h
+}
+
+// Everyone says numbered msgs at the same time, while some rename themselves
+// and others keep joining and leaving. Whoever is in the room the whole time
+// gets every msg of the others, and everybody gets the msgs of one sender in
+// the order they were said. Run it with -race.
+func TestChatroomConcurrent(t *testing.T) {
+	const (
+		stayers  = 5
+		churners = 3
+		says     = 200
+	)
+	room := NewChatroom(NewMailboxDelivery(16, Block)).Use(SlashCommands())
+
+	people := make([]*Person, stayers)
+	heard := make([]*hearing, stayers)
+	var wg sync.WaitGroup
+	for i := range people {
+		people[i] = NewPerson(fmt.Sprint("S", i))
+		heard[i] = listen(people[i])
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := room.Join(people[i]); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var churnHeard []*hearing
+	var chmu sync.Mutex
+	for i := range people {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := people[i]
+			for n := 1; n <= says; n++ {
+				if err := p.Say(fmt.Sprintf("S%d %d", i, n)); err != nil {
+					t.Errorf("S%d: %v", i, err)
+					return
+				}
+				// the odd ones keep changing name while they talk
+				if i%2 == 1 && n%20 == 0 {
+					if err := p.Say(fmt.Sprintf("/nick S%d-%d", i, n)); err != nil {
+						t.Errorf("S%d: %v", i, err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := range churners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n := 0
+			for round := range 20 {
+				p := NewPerson(fmt.Sprintf("C%d-%d", i, round))
+				h := listen(p)
+				chmu.Lock()
+				churnHeard = append(churnHeard, h)
+				chmu.Unlock()
+				if err := room.Join(p); err != nil {
+					t.Errorf("C%d: %v", i, err)
+					return
+				}
+				for range 5 {
+					n++
+					if err := p.Say(fmt.Sprintf("C%d %d", i, n)); err != nil {
+						t.Errorf("C%d: %v", i, err)
+						return
+					}
+				}
+				if !room.Leave(p) {
+					t.Errorf("C%d was not in the room", i)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	room.Close()
+
+	for i, h := range append(heard, churnHeard...) {
+		for _, b := range h.bad {
+			t.Errorf("listener %d: out of order: %s", i, b)
+		}
+	}
+	for i, h := range heard {
+		for j := range stayers {
+			want := says
+			if i == j {
+				want = 0 // nobody hears themselves
+			}
+			if got := h.count[fmt.Sprint("S", j)]; got != want {
+				t.Errorf("S%d got %d msgs of S%d, want %d", i, got, j, want)
+			}
+		}
+	}
+	if m := room.Members(); len(m) != stayers {
+		t.Errorf("left in the room: %v", m)
+	}
+}
+
+// a rename racing with joins never lets two people have the same name.
+func TestRenameRacesJoin(t *testing.T) {
+	for range 50 {
+		room := NewChatroom(nil)
+		a, b := &Person{Name: "a", Out: io.Discard}, &Person{Name: "b", Out: io.Discard}
+		room.Join(a)
+		var errs [2]error
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); errs[0] = rename(a, "x") }()
+		go func() { defer wg.Done(); b.Name = "x"; errs[1] = room.Join(b) }()
+		wg.Wait()
+		if (errs[0] == nil) == (errs[1] == nil) {
+			t.Fatalf("rename: %v, join: %v, members %v", errs[0], errs[1], room.Members())
+		}
+		room.Close()
+	}
+}
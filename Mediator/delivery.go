@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// The room used to call p.Receive for everybody in a loop, on the go routine
+// of whoever said something. So one slow person (a bot doing I/O, a client on
+// a bad network) held up the sender, and with that everybody after them in the
+// loop. How a msg gets from the room to a person is a strategy now:
+//
+//   - SyncDelivery is the old behaviour, Receive is called right away.
+//   - MailboxDelivery gives every person a buffered mailbox and a go routine
+//     of it's own which calls Receive, so the sender only puts the msg in the
+//     mailbox and goes on. What happens when a mailbox is full is the
+//     Backpressure policy.
+
+var (
+	ErrDisconnected = errors.New("mailbox full, receiver disconnected")
+	ErrClosed       = errors.New("delivery closed")
+)
+
+type Delivery interface {
+	// Deliver hands msg to p. An error means p can't be delivered to any
+	// more and the room should drop p.
+	Deliver(p *Person, msg Message) error
+	// Remove stops delivering to p, e.g. when p leaves the room.
+	Remove(p *Person)
+	// Close waits for everything already handed over to be delivered.
+	Close()
+}
+
+type SyncDelivery struct{}
+
+func (SyncDelivery) Deliver(p *Person, msg Message) error {
+	p.Receive(msg)
+	return nil
+}
+
+func (SyncDelivery) Remove(p *Person) {}
+func (SyncDelivery) Close()           {}
+
+// Backpressure says what to do with a msg for a full mailbox.
+type Backpressure int
+
+const (
+	Block      Backpressure = iota // sender waits for room in the mailbox
+	DropOldest                     // oldest msg in the mailbox goes, new one is kept
+	DropNewest                     // new msg goes, mailbox is left as it is
+	Disconnect                     // receiver is too slow, throw them out of the room
+)
+
+func (b Backpressure) String() string {
+	switch b {
+	case Block:
+		return "block"
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case Disconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+type mailbox struct {
+	ch   chan Message
+	mu   sync.Mutex    // so that two senders dropping the oldest don't race
+	quit chan struct{} // closed on Remove/Close
+}
+
+// MailboxDelivery is only as slow as the policy allows: with everything but
+// Block a send never waits for the receiver.
+type MailboxDelivery struct {
+	size   int
+	policy Backpressure
+
+	mu      sync.Mutex
+	boxes   map[*Person]*mailbox
+	gone    map[*Person]bool // disconnected, until the room Removes them too
+	wg      sync.WaitGroup
+	closed  bool
+	dropped atomic.Int64
+}
+
+func NewMailboxDelivery(size int, policy Backpressure) *MailboxDelivery {
+	if size < 1 {
+		size = 1
+	}
+	return &MailboxDelivery{
+		size:   size,
+		policy: policy,
+		boxes:  map[*Person]*mailbox{},
+		gone:   map[*Person]bool{},
+	}
+}
+
+// mailbox of p, started on the first msg for p.
+func (d *MailboxDelivery) mailbox(p *Person) (*mailbox, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, ErrClosed
+	}
+	if d.gone[p] {
+		return nil, ErrDisconnected
+	}
+	m, ok := d.boxes[p]
+	if !ok {
+		m = &mailbox{ch: make(chan Message, d.size), quit: make(chan struct{})}
+		d.boxes[p] = m
+		d.wg.Add(1)
+		go d.run(p, m)
+	}
+	return m, nil
+}
+
+func (d *MailboxDelivery) run(p *Person, m *mailbox) {
+	defer d.wg.Done()
+	for {
+		select {
+		case e := <-m.ch:
+			p.Receive(e)
+		case <-m.quit:
+			// whatever is already in the mailbox still gets delivered
+			for {
+				select {
+				case e := <-m.ch:
+					p.Receive(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (d *MailboxDelivery) Deliver(p *Person, msg Message) error {
+	m, err := d.mailbox(p)
+	if err != nil {
+		return err
+	}
+	switch d.policy {
+	case Block:
+		select {
+		case m.ch <- msg:
+			return nil
+		case <-m.quit:
+			return ErrClosed
+		}
+	case DropOldest:
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for {
+			select {
+			case m.ch <- msg:
+				return nil
+			default:
+			}
+			select {
+			case <-m.ch:
+				d.dropped.Add(1)
+			default: // the receiver just took one, try again
+			}
+		}
+	default:
+		select {
+		case m.ch <- msg:
+			return nil
+		default:
+		}
+		d.dropped.Add(1)
+		if d.policy == Disconnect {
+			d.disconnect(p)
+			return ErrDisconnected
+		}
+		return nil
+	}
+}
+
+// disconnect stops the mailbox of p, and keeps p from getting a new one.
+// The room drops p only after the msgs it is delivering right now, some of
+// which may still be for p; those fail too rather than start a new mailbox.
+func (d *MailboxDelivery) disconnect(p *Person) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if m, ok := d.boxes[p]; ok {
+		close(m.quit)
+		delete(d.boxes, p)
+	}
+	d.gone[p] = true
+}
+
+// Remove is the room letting go of p, after which p can join again.
+func (d *MailboxDelivery) Remove(p *Person) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if m, ok := d.boxes[p]; ok {
+		close(m.quit)
+		delete(d.boxes, p)
+	}
+	delete(d.gone, p)
+}
+
+func (d *MailboxDelivery) Close() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for p, m := range d.boxes {
+			close(m.quit)
+			delete(d.boxes, p)
+		}
+		clear(d.gone)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// Dropped is how many msgs the policy threw away so far.
+func (d *MailboxDelivery) Dropped() int {
+	return int(d.dropped.Load())
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a person who takes the first msg and then sits on it until let go.
+func stuck(name string) (p *Person, got func() []string, letGo func()) {
+	var (
+		mu   sync.Mutex
+		seen []string
+		once sync.Once
+	)
+	hold := make(chan struct{})
+	p = &Person{Name: name, Out: io.Discard, OnReceive: func(m Message) {
+		mu.Lock()
+		seen = append(seen, m.Text)
+		n := len(seen)
+		mu.Unlock()
+		if n == 1 {
+			<-hold
+		}
+	}}
+	got = func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), seen...)
+	}
+	letGo = func() { once.Do(func() { close(hold) }) }
+	return p, got, letGo
+}
+
+func TestDisconnectStaysDisconnected(t *testing.T) {
+	d := NewMailboxDelivery(1, Disconnect)
+	p, got, letGo := stuck("slow")
+
+	if err := d.Deliver(p, Message{Text: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	for len(got()) == 0 {
+		time.Sleep(time.Millisecond) // until 1 is being received
+	}
+	if err := d.Deliver(p, Message{Text: "2"}); err != nil {
+		t.Fatal(err) // fills the mailbox
+	}
+	if err := d.Deliver(p, Message{Text: "3"}); err != ErrDisconnected {
+		t.Fatalf("3: got %v, want ErrDisconnected", err)
+	}
+	// more for p in the same batch must not start a new mailbox
+	if err := d.Deliver(p, Message{Text: "4"}); err != ErrDisconnected {
+		t.Fatalf("4: got %v, want ErrDisconnected", err)
+	}
+	letGo()
+	time.Sleep(10 * time.Millisecond)
+	if g := got(); len(g) != 2 || g[0] != "1" || g[1] != "2" {
+		t.Fatalf("got %v, want [1 2]", g)
+	}
+
+	// once the room is done with p, p can be delivered to again
+	d.Remove(p)
+	if err := d.Deliver(p, Message{Text: "5"}); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	if g := got(); len(g) != 3 || g[2] != "5" {
+		t.Fatalf("got %v, want [1 2 5]", g)
+	}
+	if d.Dropped() != 1 { // 4 never got to a mailbox
+		t.Fatalf("Dropped = %d, want 1", d.Dropped())
+	}
+}
+
+// the room drops slow, and slow gets no more than what fit in the mailbox.
+func TestDisconnectInRoom(t *testing.T) {
+	const size = 2
+	d := NewMailboxDelivery(size, Disconnect)
+	room := NewChatroom(d)
+	defer room.Close()
+	slow, got, letGo := stuck("slow")
+	defer letGo()
+	talker := &Person{Name: "talker", Out: io.Discard}
+
+	room.Join(slow)
+	room.Join(talker) // slow gets stuck on this one
+	for len(got()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; slow.CurrentRoom() != nil; i++ {
+		if i == 100 {
+			t.Fatal("slow was never disconnected")
+		}
+		if err := talker.Say("hi"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m := room.Members(); len(m) != 1 || m[0] != "talker" {
+		t.Fatalf("members %v, want [talker]", m)
+	}
+	letGo()
+	room.Close()
+	if g := got(); len(g) != 1+size {
+		t.Fatalf("slow got %d msgs, want %d: %v", len(g), 1+size, g)
+	}
+	var left bool
+	for _, m := range talker.chatLog {
+		left = left || (m.Kind == Leave && m.Meta["reason"] == "disconnected")
+	}
+	if !left {
+		t.Fatal("talker was not told slow was disconnected")
+	}
+}
+
+// hangingOut is the Out of a client which stopped reading: the first write
+// never returns until let go.
+type hangingOut struct {
+	once    sync.Once
+	hung    chan struct{} // closed once a write hangs
+	release chan struct{}
+}
+
+func newHangingOut() *hangingOut {
+	return &hangingOut{hung: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (h *hangingOut) Write(b []byte) (int, error) {
+	h.once.Do(func() { close(h.hung) })
+	<-h.release
+	return len(b), nil
+}
+
+// a write to Out which hangs holds up nobody but whoever it is for: the room
+// still kicks, tells presence and throws out the too slow.
+func TestHangingOutHoldsUpNobody(t *testing.T) {
+	// 2 so that A, who is not slow, keeps up with the news below for sure
+	room := NewChatroom(NewMailboxDelivery(2, Disconnect))
+	out1, out2 := newHangingOut(), newHangingOut()
+	s1 := &Person{Name: "S1", Out: out1}
+	s2 := &Person{Name: "S2", Out: out2}
+	a := &Person{Name: "A", Out: io.Discard}
+	room.Join(s1)
+	room.Join(s2) // S1 hangs on this
+	<-out1.hung
+	room.Join(a) // and S2 on this
+	<-out2.hung
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if pr := room.Presence(); len(pr) != 3 {
+			t.Errorf("Presence = %v", pr)
+		}
+		// and the one hanging is still there to ask
+		s2.Presence()
+		s2.SetRenderer(JSON{})
+		s2.Rooms()
+		if err := room.Kick("A", "S1"); err != nil {
+			t.Errorf("Kick: %v", err)
+		}
+		// S2's mailbox has the kick, the first fills it and the second
+		// throws S2 out
+		a.Say("anyone?")
+		a.Say("hello?")
+		if m := room.Members(); !slices.Equal(m, []string{"A"}) {
+			t.Errorf("Members = %v, want just A", m)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the room is held up by the hanging writes")
+	}
+	close(out1.release)
+	close(out2.release)
+	room.Close()
+}
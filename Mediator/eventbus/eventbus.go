@@ -0,0 +1,259 @@
+// Package eventbus is the Mediator pattern w/o the chat: components publish
+// typed events on topics and subscribe to the topics they care about, and
+// none of them knows about any other.
+//
+// Topics are words joined by dots, e.g. "chat.ops.join". A subscription is
+// for a pattern, in which a "*" matches exactly one word and a ">" (only as
+// the last word) one or more words:
+//
+//	chat.*.join   chat.ops.join, chat.dev.join
+//	chat.>        chat.ops.join, chat.dev.topic.set
+//
+// A subscriber also says what type of event it wants, and gets only the
+// events of that type (or, for an interface type, implementing it):
+//
+//	eventbus.Subscribe(bus, "chat.*.join", func(topic string, m Message) { ... })
+//	eventbus.Publish(bus, "chat.ops.join", m)
+//
+// A Subscribe handler runs right away on the go routine of the publisher, in
+// the order the subscriptions were made. SubscribeAsync gives a handler a
+// queue and a go routine of it's own instead, so a slow one does not hold up
+// the publisher (unless the queue is full). Either way one subscriber gets
+// the events of one publisher in the order they were published.
+//
+// On top of that there is request/response: Request publishes a request and
+// waits for the first reply to it, and Respond answers requests. Both go by
+// the request and the response type, a responder for the right Req but
+// another Resp does not count.
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrNoResponder = errors.New("eventbus: nobody to answer the request")
+
+type Bus struct {
+	mu     sync.RWMutex
+	subs   []*Subscription // in the order they were made
+	closed bool
+
+	wg      sync.WaitGroup // the async subscriptions
+	nextReq atomic.Uint64
+}
+
+func New() *Bus {
+	return &Bus{}
+}
+
+// Subscription is the handle to unsubscribe with.
+type Subscription struct {
+	bus     *Bus
+	pattern []string
+	deliver func(topic string, ev any) bool // false if ev is not the right type
+
+	// just for SubscribeAsync
+	queue chan event
+	quit  chan struct{}
+	once  sync.Once
+}
+
+type event struct {
+	topic string
+	ev    any
+}
+
+// Subscribe calls h with every event of type T published on a topic matching
+// pattern, on the go routine of the publisher.
+func Subscribe[T any](b *Bus, pattern string, h func(topic string, ev T)) *Subscription {
+	s := &Subscription{bus: b, pattern: strings.Split(pattern, ".")}
+	s.deliver = func(topic string, ev any) bool {
+		v, ok := ev.(T)
+		if ok {
+			h(topic, v)
+		}
+		return ok
+	}
+	b.add(s)
+	return s
+}
+
+// SubscribeAsync is Subscribe, but h runs on a go routine of it's own, with
+// a queue of size events. Publish waits while the queue is full.
+func SubscribeAsync[T any](b *Bus, pattern string, size int, h func(topic string, ev T)) *Subscription {
+	s := &Subscription{
+		bus:     b,
+		pattern: strings.Split(pattern, "."),
+		queue:   make(chan event, max(size, 1)),
+		quit:    make(chan struct{}),
+	}
+	s.deliver = func(topic string, ev any) bool {
+		if _, ok := ev.(T); !ok {
+			return false
+		}
+		select {
+		case s.queue <- event{topic, ev}:
+		case <-s.quit: // unsubscribed meanwhile
+		}
+		return true
+	}
+	if !b.add(s) {
+		return s
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for {
+			select {
+			case e := <-s.queue:
+				h(e.topic, e.ev.(T))
+			case <-s.quit:
+				// what is already queued still gets handled
+				for {
+					select {
+					case e := <-s.queue:
+						h(e.topic, e.ev.(T))
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return s
+}
+
+// add reports false if the bus is closed, s is dead then.
+func (b *Bus) add(s *Subscription) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		s.stop()
+		return false
+	}
+	b.subs = append(b.subs, s)
+	return true
+}
+
+// Unsubscribe stops any more events going to s. Doing it twice is fine. An
+// async handler still gets what is already in it's queue.
+func (s *Subscription) Unsubscribe() {
+	b := s.bus
+	b.mu.Lock()
+	for i, o := range b.subs {
+		if o == s {
+			// a new slice, a Publish may still be going through the old one
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			break
+		}
+	}
+	b.mu.Unlock()
+	s.stop()
+}
+
+func (s *Subscription) stop() {
+	if s.quit != nil {
+		s.once.Do(func() { close(s.quit) })
+	}
+}
+
+// Publish hands ev to every subscription for topic and type T, and gives how
+// many there were. topic has no wildcards.
+func Publish[T any](b *Bus, topic string, ev T) int {
+	words := strings.Split(topic, ".")
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+	n := 0
+	for _, s := range subs {
+		if match(s.pattern, words) && s.deliver(topic, ev) {
+			n++
+		}
+	}
+	return n
+}
+
+func match(pattern, topic []string) bool {
+	for i, p := range pattern {
+		switch {
+		case p == ">" && i == len(pattern)-1:
+			return len(topic) > i
+		case i >= len(topic):
+			return false
+		case p != "*" && p != topic[i]:
+			return false
+		}
+	}
+	return len(pattern) == len(topic)
+}
+
+// Close unsubscribes everyone, and waits for the async handlers to be done
+// with what they have queued.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	subs := b.subs
+	b.subs, b.closed = nil, true
+	b.mu.Unlock()
+	for _, s := range subs {
+		s.stop()
+	}
+	b.wg.Wait()
+}
+
+// a request goes out on the topic asked, and the reply comes back on a topic
+// of it's own, made up for just that request. That is how a reply finds it's
+// way back to the one request it is for. Resp is in the type too, so that
+// only a responder whose reply the requester takes gets the request (and
+// with nobody like that Publish says 0, rather than a reply going nowhere).
+type request[Req, Resp any] struct {
+	body    Req
+	replyTo string
+}
+
+type reply[Resp any] struct {
+	body Resp
+	err  error
+}
+
+// Request publishes req on topic and waits for the first answer from a
+// Respond[Req, Resp], or for ctx to be done.
+func Request[Req, Resp any](ctx context.Context, b *Bus, topic string, req Req) (Resp, error) {
+	replyTo := "_inbox." + strconv.FormatUint(b.nextReq.Add(1), 10)
+	replies := make(chan reply[Resp], 1)
+	sub := Subscribe(b, replyTo, func(_ string, r reply[Resp]) {
+		select {
+		case replies <- r:
+		default: // someone answered first
+		}
+	})
+	defer sub.Unsubscribe()
+
+	// the responders run on a go routine of their own, so that a slow one
+	// does not keep us past ctx.
+	go func() {
+		if Publish(b, topic, request[Req, Resp]{req, replyTo}) == 0 {
+			replies <- reply[Resp]{err: ErrNoResponder}
+		}
+	}()
+	select {
+	case r := <-replies:
+		return r.body, r.err
+	case <-ctx.Done():
+		var zero Resp
+		return zero, ctx.Err()
+	}
+}
+
+// Respond answers every request of type Req on a topic matching pattern with
+// whatever h gives, error included.
+func Respond[Req, Resp any](b *Bus, pattern string, h func(topic string, req Req) (Resp, error)) *Subscription {
+	return Subscribe(b, pattern, func(topic string, r request[Req, Resp]) {
+		body, err := h(topic, r.body)
+		Publish(b, r.replyTo, reply[Resp]{body, err})
+	})
+}
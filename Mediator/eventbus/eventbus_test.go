@@ -0,0 +1,245 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"chat.ops.join", "chat.ops.join", true},
+		{"chat.ops.join", "chat.dev.join", false},
+		{"chat.*.join", "chat.dev.join", true},
+		{"chat.*.join", "chat.dev.leave", false},
+		{"chat.*.join", "chat.join", false},
+		{"chat.*", "chat.ops.join", false},
+		{"chat.>", "chat.ops", true},
+		{"chat.>", "chat.ops.topic.set", true},
+		{"chat.>", "chat", false},
+		{">", "anything.at.all", true},
+		{"chat.>.join", "chat.ops.join", false}, // > only counts last
+		{"*.*", "chat.ops", true},
+		{"chat", "chat.ops", false},
+	} {
+		got := match(strings.Split(c.pattern, "."), strings.Split(c.topic, "."))
+		if got != c.want {
+			t.Errorf("match(%q, %q) = %v, want %v", c.pattern, c.topic, got, c.want)
+		}
+	}
+}
+
+type joined struct{ who string }
+
+func (j joined) String() string { return j.who + " joined" }
+
+// a subscriber gets the events of it's type only, an interface type taking
+// whatever implements it.
+func TestTypes(t *testing.T) {
+	b := New()
+	defer b.Close()
+	var got []string
+	Subscribe(b, "chat.>", func(topic string, j joined) { got = append(got, "joined "+j.who) })
+	Subscribe(b, "chat.>", func(topic string, s fmt.Stringer) { got = append(got, "stringer "+s.String()) })
+	Subscribe(b, "chat.>", func(topic string, s string) { got = append(got, "string "+s) })
+
+	if n := Publish(b, "chat.ops.join", joined{"ann"}); n != 2 {
+		t.Fatalf("Publish joined reached %d, want 2", n)
+	}
+	if n := Publish(b, "chat.ops.say", "hi"); n != 1 {
+		t.Fatalf("Publish string reached %d, want 1", n)
+	}
+	if n := Publish(b, "chat.ops.say", 42); n != 0 {
+		t.Fatalf("Publish int reached %d, want 0", n)
+	}
+	want := "[joined ann stringer ann joined string hi]"
+	if fmt.Sprint(got) != want {
+		t.Fatalf("got %v, want %s", got, want)
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	b := New()
+	defer b.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	Respond(b, "math.double", func(_ string, n int) (int, error) {
+		if n < 0 {
+			return 0, errors.New("negative")
+		}
+		return 2 * n, nil
+	})
+	if got, err := Request[int, int](ctx, b, "math.double", 21); err != nil || got != 42 {
+		t.Fatalf("got %d, %v, want 42", got, err)
+	}
+	if _, err := Request[int, int](ctx, b, "math.double", -1); err == nil || err.Error() != "negative" {
+		t.Fatalf("got %v, want the error of the responder", err)
+	}
+	if _, err := Request[int, int](ctx, b, "math.triple", 1); !errors.Is(err, ErrNoResponder) {
+		t.Fatalf("nobody on the topic: got %v, want ErrNoResponder", err)
+	}
+	// the right Req but another Resp is nobody either, even w/o a deadline
+	done := make(chan error, 1)
+	go func() {
+		_, err := Request[int, string](context.Background(), b, "math.double", 1)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrNoResponder) {
+			t.Fatalf("Resp of another type: got %v, want ErrNoResponder", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Request with a Resp nobody gives hangs")
+	}
+
+	// a slow responder is left behind once ctx is done
+	hold := make(chan struct{})
+	defer close(hold)
+	Respond(b, "slow", func(string, int) (int, error) {
+		<-hold
+		return 0, nil
+	})
+	short, cancel2 := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel2()
+	if _, err := Request[int, int](short, b, "slow", 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("slow: got %v, want DeadlineExceeded", err)
+	}
+}
+
+// many requests at once each get their own answer back.
+func TestConcurrentRequests(t *testing.T) {
+	b := New()
+	defer b.Close()
+	Respond(b, "echo", func(_ string, n int) (int, error) { return n, nil })
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := Request[int, int](context.Background(), b, "echo", i)
+			if err != nil || got != i {
+				t.Errorf("echo %d: got %d, %v", i, got, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// an async subscriber gets everything, in order, and Close waits for it.
+func TestAsync(t *testing.T) {
+	b := New()
+	var got []int
+	hold := make(chan struct{})
+	SubscribeAsync(b, "n", 2, func(_ string, n int) {
+		<-hold
+		got = append(got, n)
+	})
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := range 10 {
+			Publish(b, "n", i) // waits once the queue is full
+		}
+	}()
+	select {
+	case <-published:
+		t.Fatal("Publish did not wait for a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(hold)
+	<-published
+	b.Close()
+	if fmt.Sprint(got) != "[0 1 2 3 4 5 6 7 8 9]" {
+		t.Fatalf("got %v", got)
+	}
+}
+
+// subscribing and unsubscribing while others publish, from the handlers
+// too, run with -race.
+func TestUnsubscribeDuringPublish(t *testing.T) {
+	b := New()
+	defer b.Close()
+	var self, other *Subscription
+	var selfN, otherN atomic.Int64
+	self = Subscribe(b, "x", func(string, int) {
+		selfN.Add(1)
+		self.Unsubscribe()
+		other.Unsubscribe()
+	})
+	other = Subscribe(b, "x", func(string, int) { otherN.Add(1) })
+	// other is still in the slice this Publish goes through
+	if n := Publish(b, "x", 1); n != 2 {
+		t.Fatalf("first Publish reached %d, want 2", n)
+	}
+	if n := Publish(b, "x", 2); n != 0 {
+		t.Fatalf("after unsubscribing: reached %d", n)
+	}
+	self.Unsubscribe() // twice is fine
+	if selfN.Load() != 1 || otherN.Load() != 1 {
+		t.Fatalf("handlers ran %d and %d times", selfN.Load(), otherN.Load())
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					Publish(b, "y.z", 1)
+				}
+			}
+		}()
+	}
+	for range 200 {
+		s := Subscribe(b, "y.*", func(string, int) {})
+		a := SubscribeAsync(b, "y.>", 1, func(string, int) {})
+		s.Unsubscribe()
+		a.Unsubscribe()
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestClose(t *testing.T) {
+	b := New()
+	var handled atomic.Int64
+	SubscribeAsync(b, "n", 100, func(string, int) {
+		time.Sleep(time.Millisecond)
+		handled.Add(1)
+	})
+	for i := range 10 {
+		Publish(b, "n", i)
+	}
+	b.Close()
+	if handled.Load() != 10 {
+		t.Fatalf("Close returned with %d of 10 handled", handled.Load())
+	}
+	if n := Publish(b, "n", 1); n != 0 {
+		t.Fatalf("Publish after Close reached %d", n)
+	}
+	// subscribing to a closed bus gives a dead subscription
+	SubscribeAsync(b, "n", 1, func(string, int) { t.Error("handler on a closed bus") })
+	Subscribe(b, "n", func(string, int) { t.Error("handler on a closed bus") })
+	if n := Publish(b, "n", 1); n != 0 {
+		t.Fatalf("Publish after Close reached %d", n)
+	}
+	if _, err := Request[int, int](context.Background(), b, "n", 1); !errors.Is(err, ErrNoResponder) {
+		t.Fatalf("Request after Close: %v", err)
+	}
+	b.Close() // twice is fine
+}
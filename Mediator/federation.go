@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/riteshharjani/design-pattens-go/Mediator/eventbus"
+)
+
+// Federation bridges rooms of the same name in different processes, e.g. an
+// "incidents" room in every service, so that what is said in one of them
+// shows up in all. Each process has a Bridge for it's room, and the bridges
+// are linked in pairs over any net.Conn: one side Listens, the other
+// Connects (and keeps reconnecting when the link breaks).
+//
+// Only chat msgs cross a link. Every one is tagged with where it was first
+// said (it's origin, the name of the bridge there) and it's ID there, and
+// shows up as said by "alice@svc-a". The IDs of a room start again at 1 when
+// the process does (unless it has a history store), so every bridge also has
+// an epoch, made up at random by NewBridge, which goes with the ID: "7" of
+// svc-a after a restart is not the "7" of svc-a before it. The room itself is the mediator for
+// the bridge too: the bridge hears the room on it's eventbus, and puts what
+// comes over a link straight into the room, past the interceptors (the room
+// it was said in has already had it's say about it).
+//
+// Links can be chained or even go round in circles (a-b, b-c, c-a). A msg
+// is not sent to a bridge it has been through already (the via list), and
+// a bridge drops a msg it has seen before (by origin, epoch and ID), or one
+// which is it's own.
+//
+// What goes over a link is one json frame per line: a hello each way first,
+// then msgs, each of which the other side acks. A msg stays queued for a
+// peer until it is acked, so one said while the link is down (or lost with
+// it) is sent once the link is back. At most maxQueued are kept per peer,
+// the oldest go first. A peer is known from it's first link on, what is
+// said before that stays in the room.
+
+var (
+	ErrWrongRoom   = errors.New("federation: the peer bridges another room")
+	ErrSameOrigin  = errors.New("federation: the peer has our own name")
+	ErrBridgeClose = errors.New("federation: bridge closed")
+)
+
+const (
+	maxQueued = 256  // unacked msgs per peer
+	maxSeen   = 4096 // msgs remembered, to drop the ones which come round again
+
+	retryMin = 100 * time.Millisecond
+	retryMax = 5 * time.Second
+)
+
+// fedMsg is a msg as it crosses a link.
+type fedMsg struct {
+	Origin string            `json:"origin"`
+	Epoch  string            `json:"epoch"` // of the origin bridge
+	ID     uint64            `json:"id"`    // in the room of the origin
+	Via    []string          `json:"via"`   // the bridges it went through, origin first
+	Sender string            `json:"sender"`
+	Text   string            `json:"text"`
+	Meta   map[string]string `json:"meta,omitempty"`
+}
+
+type hello struct {
+	Origin string `json:"origin"`
+	Epoch  string `json:"epoch"`
+	Room   string `json:"room"`
+}
+
+type frame struct {
+	Hello *hello  `json:"hello,omitempty"`
+	Seq   uint64  `json:"seq,omitempty"`
+	Msg   *fedMsg `json:"msg,omitempty"`
+	Ack   uint64  `json:"ack,omitempty"`
+}
+
+type Bridge struct {
+	Origin string // this side's name, unique among the linked bridges
+	epoch  string // this instance of it
+	room   *Chatroom
+	sub    *eventbus.Subscription
+
+	mu        sync.Mutex
+	peers     map[string]*peer // by origin, they outlive the links
+	seen      map[string]bool  // origin/epoch/id
+	seenOrder []string
+	links     map[*link]struct{}
+	closed    bool
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// peer is another bridge, and what is queued for it.
+type peer struct {
+	origin  string
+	epoch   string  // from it's last hello, a new one means it restarted
+	link    *link   // the one to write on, nil while down
+	queue   []frame // not acked yet, in seq order
+	sent    int     // how many of queue went out on link
+	nextSeq uint64
+	wake    chan struct{}
+}
+
+// link is one connection to a peer.
+type link struct {
+	conn net.Conn
+	enc  *json.Encoder
+	done chan struct{}
+	once sync.Once
+
+	// the last seq received, and acked so far (under Bridge.mu). The acks
+	// go out with the msgs, so that reading never waits for writing.
+	received, acked uint64
+}
+
+func (l *link) close() {
+	l.once.Do(func() {
+		close(l.done)
+		l.conn.Close()
+	})
+}
+
+// NewBridge bridges room as origin. The room is put on an eventbus of it's
+// own if it is not on one already, see bus.go.
+func NewBridge(origin string, room *Chatroom) *Bridge {
+	var e [8]byte
+	rand.Read(e[:])
+	b := &Bridge{
+		Origin: origin,
+		epoch:  hex.EncodeToString(e[:]),
+		room:   room,
+		peers:  map[string]*peer{},
+		seen:   map[string]bool{},
+		links:  map[*link]struct{}{},
+		quit:   make(chan struct{}),
+	}
+	room.mu.Lock()
+	bus, name := room.bus, room.Name
+	room.mu.Unlock()
+	if bus == nil {
+		bus = eventbus.New()
+		room.WithBus(bus)
+	}
+	b.sub = eventbus.Subscribe(bus, roomTopic(name)+".chat", func(_ string, m Message) {
+		b.forward(m)
+	})
+	return b
+}
+
+// forward queues m for every peer it has not been through yet.
+func (b *Bridge) forward(m Message) {
+	f := fedMsg{Origin: b.Origin, Epoch: b.epoch, ID: m.ID, Via: []string{b.Origin}, Sender: m.Sender, Text: m.Text}
+	meta := map[string]string{}
+	for k, v := range m.Meta {
+		meta[k] = v
+	}
+	if origin := meta["origin"]; origin != "" { // it came over a link
+		f.Origin, f.Epoch = origin, meta["origin_epoch"]
+		f.ID, _ = strconv.ParseUint(meta["origin_id"], 10, 64)
+		f.Via = append(strings.Split(meta["via"], ","), b.Origin)
+		f.Sender = strings.TrimSuffix(m.Sender, "@"+origin)
+		delete(meta, "origin")
+		delete(meta, "origin_epoch")
+		delete(meta, "origin_id")
+		delete(meta, "via")
+	}
+	if len(meta) > 0 {
+		f.Meta = meta
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	for _, p := range b.peers {
+		if p.origin != f.Origin && !slices.Contains(f.Via, p.origin) {
+			p.enqueue(f)
+		}
+	}
+}
+
+// enqueue is called with b.mu held.
+func (p *peer) enqueue(f fedMsg) {
+	if len(p.queue) == maxQueued {
+		p.queue = slices.Delete(p.queue, 0, 1)
+		p.sent = max(p.sent-1, 0)
+	}
+	p.nextSeq++
+	p.queue = append(p.queue, frame{Seq: p.nextSeq, Msg: &f})
+	p.signal()
+}
+
+func (p *peer) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// receive puts f in the room, unless it has been here before.
+func (b *Bridge) receive(f fedMsg) {
+	key := f.Origin + "/" + f.Epoch + "/" + strconv.FormatUint(f.ID, 10)
+	b.mu.Lock()
+	if f.Origin == b.Origin || b.seen[key] || b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.seen[key] = true
+	b.seenOrder = append(b.seenOrder, key)
+	if len(b.seenOrder) > maxSeen {
+		delete(b.seen, b.seenOrder[0])
+		b.seenOrder = slices.Delete(b.seenOrder, 0, 1)
+	}
+	b.mu.Unlock()
+
+	m := Message{Kind: Chat, Sender: f.Sender + "@" + f.Origin, Text: f.Text, Meta: map[string]string{}}
+	for k, v := range f.Meta {
+		m.Meta[k] = v
+	}
+	m.Meta["origin"] = f.Origin
+	m.Meta["origin_epoch"] = f.Epoch
+	m.Meta["origin_id"] = strconv.FormatUint(f.ID, 10)
+	m.Meta["via"] = strings.Join(f.Via, ",")
+	b.room.relay(m)
+}
+
+// relay says m in the room as it is, past the interceptors.
+func (c *Chatroom) relay(m Message) {
+	c.mu.Lock()
+	defer c.unlock()
+	if !c.closed {
+		c.send(m)
+	}
+}
+
+// spawn runs f on a go routine which Close waits for, unless the bridge is
+// closed already.
+func (b *Bridge) spawn(f func()) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		f()
+	}()
+	return true
+}
+
+// Listen takes links from l until it is closed, or the bridge is.
+func (b *Bridge) Listen(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		if !b.spawn(func() { b.Serve(conn) }) {
+			conn.Close()
+			return ErrBridgeClose
+		}
+	}
+}
+
+// Connect keeps a link up to the peer dial gets to, dialing again (a bit
+// later every time it fails) whenever the link breaks, until Close.
+func (b *Bridge) Connect(dial func() (net.Conn, error)) {
+	b.spawn(func() {
+		wait := retryMin
+		for {
+			if conn, err := dial(); err == nil {
+				start := time.Now()
+				b.Serve(conn)
+				if time.Since(start) > retryMax {
+					wait = retryMin // it was up for a while
+				}
+			}
+			select {
+			case <-b.quit:
+				return
+			case <-time.After(wait):
+			}
+			wait = min(2*wait, retryMax)
+		}
+	})
+}
+
+// Serve runs one link over conn until it breaks, Listen and Connect are
+// made of this. Any other way of getting a net.Conn to the peer works too,
+// e.g. one end of a net.Pipe. Close ends it, but waits only for the ones
+// Listen and Connect started.
+func (b *Bridge) Serve(conn net.Conn) error {
+	l := &link{conn: conn, enc: json.NewEncoder(conn), done: make(chan struct{})}
+	defer l.close()
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrBridgeClose
+	}
+	b.links[l] = struct{}{}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.links, l)
+		b.mu.Unlock()
+	}()
+
+	// both sides say hello at once, and a net.Pipe does not buffer
+	room := b.room.Name
+	wrote := make(chan error, 1)
+	go func() { wrote <- l.enc.Encode(frame{Hello: &hello{b.Origin, b.epoch, room}}) }()
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	var f frame
+	if err := dec.Decode(&f); err != nil {
+		return err
+	}
+	if err := <-wrote; err != nil {
+		return err
+	}
+	switch {
+	case f.Hello == nil || f.Hello.Room != room:
+		return ErrWrongRoom
+	case f.Hello.Origin == b.Origin:
+		return ErrSameOrigin
+	}
+	p := b.attach(f.Hello.Origin, f.Hello.Epoch, l)
+	defer b.detach(p, l)
+
+	if !b.spawn(func() { b.write(p, l) }) {
+		return ErrBridgeClose
+	}
+	for {
+		var f frame
+		if err := dec.Decode(&f); err != nil {
+			return err
+		}
+		switch {
+		case f.Msg != nil:
+			b.receive(*f.Msg)
+			b.mu.Lock()
+			l.received = f.Seq
+			p.signal()
+			b.mu.Unlock()
+		case f.Ack != 0:
+			b.acked(p, f.Ack)
+		}
+	}
+}
+
+// attach makes l the link to write to origin on, everything not acked yet
+// is sent (again) on it. That is all there is to a peer which restarted
+// (a new epoch), it gets what was queued while it was away as well.
+func (b *Bridge) attach(origin, epoch string, l *link) *peer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.peers[origin]
+	if !ok {
+		p = &peer{origin: origin, wake: make(chan struct{}, 1)}
+		b.peers[origin] = p
+	}
+	p.epoch, p.link, p.sent = epoch, l, 0
+	p.signal()
+	return p
+}
+
+func (b *Bridge) detach(p *peer, l *link) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p.link == l {
+		p.link = nil
+	}
+}
+
+func (b *Bridge) acked(p *peer, seq uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for n < len(p.queue) && p.queue[n].Seq <= seq {
+		n++
+	}
+	p.queue = slices.Delete(p.queue, 0, n)
+	p.sent = max(p.sent-n, 0)
+}
+
+// write sends what is queued for p, and the acks for what came in, on l
+// for as long as l is p's link.
+func (b *Bridge) write(p *peer, l *link) {
+	for {
+		b.mu.Lock()
+		if p.link != l {
+			p.signal() // in case it was meant for the new link
+			b.mu.Unlock()
+			return
+		}
+		var f frame
+		switch {
+		case l.acked < l.received:
+			f.Ack = l.received
+			l.acked = l.received
+		case p.sent < len(p.queue):
+			f = p.queue[p.sent]
+			p.sent++
+		default:
+			b.mu.Unlock()
+			select {
+			case <-p.wake:
+			case <-l.done:
+				return
+			}
+			continue
+		}
+		b.mu.Unlock()
+		if l.enc.Encode(f) != nil {
+			l.close() // what was not acked stays queued for the next link
+			return
+		}
+	}
+}
+
+// Peers gives the bridges linked to right now, sorted.
+func (b *Bridge) Peers() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var up []string
+	for o, p := range b.peers {
+		if p.link != nil {
+			up = append(up, o)
+		}
+	}
+	slices.Sort(up)
+	return up
+}
+
+// Close takes the bridge down, the room carries on alone.
+func (b *Bridge) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	close(b.quit)
+	for l := range b.links {
+		l.close()
+	}
+	b.mu.Unlock()
+	b.sub.Unsubscribe()
+	b.wg.Wait()
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// inbox is a person in a bridged room, counting the chat msgs they get.
+type inbox struct {
+	*Person
+	mu  sync.Mutex
+	got map[string]int // by sender: text
+}
+
+func newInbox(t *testing.T, name string, room *Chatroom) *inbox {
+	in := &inbox{Person: &Person{Name: name, Out: io.Discard}, got: map[string]int{}}
+	in.OnReceive = func(m Message) {
+		if m.Kind == Chat {
+			in.mu.Lock()
+			in.got[m.Sender+": "+m.Text]++
+			in.mu.Unlock()
+		}
+	}
+	if err := room.Join(in.Person); err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
+
+func (in *inbox) count(line string) int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.got[line]
+}
+
+func (in *inbox) lines() map[string]int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return maps.Clone(in.got)
+}
+
+func (in *inbox) total() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	n := 0
+	for _, c := range in.got {
+		n += c
+	}
+	return n
+}
+
+// eventually waits (a while) for ok to be true.
+func eventually(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	for i := 0; !ok(); i++ {
+		if i == 500 {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// pipeTo links from to to over a net.Pipe, again whenever it breaks.
+func pipeTo(from, to *Bridge) {
+	from.Connect(func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go to.Serve(c2)
+		return c1, nil
+	})
+}
+
+// in a ring every msg goes both ways round, and still shows up once in
+// every room.
+func TestFederationRing(t *testing.T) {
+	names := []string{"svc-a", "svc-b", "svc-c"}
+	bridges := make([]*Bridge, len(names))
+	people := make([]*inbox, len(names))
+	for i, n := range names {
+		room := &Chatroom{Name: "incidents"}
+		bridges[i] = NewBridge(n, room)
+		people[i] = newInbox(t, "P"+n[4:], room)
+		defer bridges[i].Close()
+	}
+	for i := range bridges {
+		pipeTo(bridges[i], bridges[(i+1)%len(bridges)])
+	}
+	for i, b := range bridges {
+		eventually(t, names[i]+" linked to both", func() bool { return len(b.Peers()) == 2 })
+	}
+
+	const says = 20
+	var wg sync.WaitGroup
+	for _, p := range people {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range says {
+				if err := p.Say(fmt.Sprint(n)); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, p := range people {
+		want := says * (len(people) - 1)
+		eventually(t, p.Name+" to hear everyone", func() bool { return p.total() >= want })
+		time.Sleep(50 * time.Millisecond) // for any copies still going round
+		for j, q := range people {
+			for n := range says {
+				line := fmt.Sprintf("%s@%s: %d", q.Name, names[j], n)
+				wantN := 1
+				if i == j {
+					wantN = 0 // the room it was said in has it unbridged
+				}
+				if got := p.count(line); got != wantN {
+					t.Errorf("%s got %q %d times, want %d", p.Name, line, got, wantN)
+				}
+			}
+		}
+		if p.total() != want {
+			t.Errorf("%s got %d msgs, want %d", p.Name, p.total(), want)
+		}
+	}
+}
+
+// what is said while the link is down gets across, once, when it is back.
+func TestFederationRedelivery(t *testing.T) {
+	roomA, roomB := &Chatroom{Name: "incidents"}, &Chatroom{Name: "incidents"}
+	a, b := NewBridge("svc-a", roomA), NewBridge("svc-b", roomB)
+	defer a.Close()
+	defer b.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go b.Listen(l)
+
+	var (
+		mu   sync.Mutex
+		conn net.Conn
+		down atomic.Bool
+	)
+	a.Connect(func() (net.Conn, error) {
+		if down.Load() {
+			return nil, errors.New("down")
+		}
+		c, err := net.Dial("tcp", l.Addr().String())
+		mu.Lock()
+		conn = c
+		mu.Unlock()
+		return c, err
+	})
+	alice := newInbox(t, "alice", roomA)
+	bob := newInbox(t, "bob", roomB)
+	eventually(t, "the link", func() bool { return len(a.Peers()) == 1 && len(b.Peers()) == 1 })
+
+	alice.Say("before")
+	eventually(t, "before", func() bool { return bob.count("alice@svc-a: before") == 1 })
+
+	down.Store(true)
+	mu.Lock()
+	conn.Close()
+	mu.Unlock()
+	eventually(t, "the link to go down", func() bool { return len(a.Peers()) == 0 && len(b.Peers()) == 0 })
+	for n := range 10 {
+		alice.Say(fmt.Sprint("while down ", n))
+	}
+	bob.Say("back at you")
+	time.Sleep(50 * time.Millisecond)
+	if bob.total() != 1 {
+		t.Fatalf("bob got %v with the link down", bob.lines())
+	}
+
+	down.Store(false)
+	eventually(t, "all of it", func() bool { return bob.total() == 11 && alice.total() == 1 })
+	time.Sleep(50 * time.Millisecond)
+	for n := range 10 {
+		if c := bob.count(fmt.Sprint("alice@svc-a: while down ", n)); c != 1 {
+			t.Errorf("bob got %q %d times", fmt.Sprint("while down ", n), c)
+		}
+	}
+	if bob.total() != 11 || alice.count("bob@svc-b: back at you") != 1 {
+		t.Errorf("bob got %v, alice got %v", bob.lines(), alice.lines())
+	}
+}
+
+// a restarted bridge starts it's IDs over, and what it says is not taken
+// for what the one before it said.
+func TestFederationRestart(t *testing.T) {
+	roomB := &Chatroom{Name: "incidents"}
+	b := NewBridge("svc-b", roomB)
+	defer b.Close()
+	bob := newInbox(t, "bob", roomB)
+
+	for run := range 3 {
+		roomA := &Chatroom{Name: "incidents"} // no history, IDs from 1 again
+		a := NewBridge("svc-a", roomA)
+		pipeTo(a, b)
+		alice := newInbox(t, "alice", roomA)
+		eventually(t, "the link", func() bool { return len(b.Peers()) == 1 })
+		b.mu.Lock()
+		epoch := b.peers["svc-a"].epoch
+		b.mu.Unlock()
+		if epoch != a.epoch {
+			t.Fatalf("run %d: svc-b has epoch %q for svc-a, want %q", run, epoch, a.epoch)
+		}
+		for n := range 3 {
+			alice.Say(fmt.Sprint(run, "/", n))
+		}
+		eventually(t, fmt.Sprint("run ", run), func() bool { return bob.total() == 3*(run+1) })
+		a.Close()
+		roomA.Close()
+		eventually(t, "the link to go down", func() bool { return len(b.Peers()) == 0 })
+	}
+}
+
+func TestFederationHello(t *testing.T) {
+	a := NewBridge("svc-a", &Chatroom{Name: "incidents"})
+	defer a.Close()
+	for _, tc := range []struct {
+		b    *Bridge
+		want error
+	}{
+		{NewBridge("svc-b", &Chatroom{Name: "other"}), ErrWrongRoom},
+		{NewBridge("svc-a", &Chatroom{Name: "incidents"}), ErrSameOrigin},
+	} {
+		c1, c2 := net.Pipe()
+		errs := make(chan error, 1)
+		go func() { errs <- tc.b.Serve(c2) }()
+		if err := a.Serve(c1); err != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.b.Origin, err, tc.want)
+		}
+		c1.Close()
+		<-errs
+		tc.b.Close()
+	}
+}
+
+// Close waits for the links Listen took.
+func TestFederationCloseWaits(t *testing.T) {
+	a := NewBridge("svc-a", &Chatroom{Name: "incidents"})
+	b := NewBridge("svc-b", &Chatroom{Name: "incidents"})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	listening := make(chan error, 1)
+	go func() { listening <- b.Listen(l) }()
+	a.Connect(func() (net.Conn, error) { return net.Dial("tcp", l.Addr().String()) })
+	eventually(t, "the link", func() bool { return len(b.Peers()) == 1 })
+
+	b.Close()
+	b.mu.Lock()
+	links := len(b.links)
+	b.mu.Unlock()
+	if links != 0 {
+		t.Fatalf("%d links still up after Close", links)
+	}
+	a.Close()
+	// the next one Listen takes is turned away
+	if c, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		defer c.Close()
+	}
+	if err := <-listening; err != ErrBridgeClose {
+		t.Fatalf("Listen: %v, want ErrBridgeClose", err)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/riteshharjani/design-pattens-go/Mediator/websocket"
+)
+
+// Frontend lets real clients into a Chatroom over the network, either with
+// a line based protocol over plain TCP (ServeTCP) or over a websocket
+// (ServeHTTP), one line per websocket msg. A client sends commands:
+//
+//	JOIN <name>             join the room as name
+//	SAY <text>              Say
+//	MSG <who> <text>        PrivateMessage
+//	LEAVE                   leave the room (the connection stays, JOIN again)
+//	HISTORY [before] [n]    a page of Chatroom.History (by default the last
+//	                        20, at most maxHistoryPage), one
+//	                        "HIST <id> <time> <sender>: <text>" each
+//	FORMAT plain|json|ansi  how msgs are sent, see render.go (plain for now)
+//	PRESENCE online|away|offline
+//	                        SetPresence
+//	TYPING                  tell the others you are typing
+//	READ <id>               a read receipt for msg id (json has the IDs)
+//	READBY <id>             who read msg id, as "READBY <id>: <names>"
+//	QUIT                    leave and hang up
+//
+// and every command gets back a line "OK" or "ERR <why>". In between come
+// the msgs for the person, one line each (json included).
+// Use a MailboxDelivery for the room, or else a client which does not read
+// holds up the room, see delivery.go.
+type Frontend struct {
+	room *Chatroom
+
+	cmu   sync.Mutex
+	conns map[lineConn]struct{}
+}
+
+func NewFrontend(room *Chatroom) *Frontend {
+	return &Frontend{room: room, conns: map[lineConn]struct{}{}}
+}
+
+// both kinds of connection look the same to a session.
+type lineConn interface {
+	ReadLine() (string, error)
+	WriteLine(line string) error
+	Close() error
+}
+
+type tcpConn struct {
+	net.Conn
+	sc  *bufio.Scanner
+	wmu sync.Mutex
+}
+
+func (c *tcpConn) ReadLine() (string, error) {
+	if c.sc.Scan() {
+		return strings.TrimSuffix(c.sc.Text(), "\r"), nil
+	}
+	if err := c.sc.Err(); err != nil {
+		return "", err
+	}
+	return "", net.ErrClosed
+}
+
+func (c *tcpConn) WriteLine(line string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.Write([]byte(line + "\n"))
+	return err
+}
+
+type wsConn struct {
+	*websocket.Conn
+}
+
+func (c wsConn) ReadLine() (string, error)   { return c.ReadMessage() }
+func (c wsConn) WriteLine(line string) error { return c.WriteMessage(line) }
+
+// ServeTCP takes connections from l until it is closed.
+func (f *Frontend) ServeTCP(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go f.serve(&tcpConn{Conn: c, sc: bufio.NewScanner(c)})
+	}
+}
+
+// ServeHTTP is the websocket endpoint.
+func (f *Frontend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	f.serve(wsConn{c})
+}
+
+// Close hangs up on every client.
+func (f *Frontend) Close() {
+	f.cmu.Lock()
+	defer f.cmu.Unlock()
+	for c := range f.conns {
+		c.Close()
+	}
+}
+
+var renderers = map[string]Renderer{"plain": PlainText{}, "json": JSON{}, "ansi": ANSI{}}
+
+// a session is one connection, and the person on it once they have joined.
+type session struct {
+	conn     lineConn
+	p        *Person
+	renderer Renderer
+}
+
+// Write makes the session the Out of it's person.
+func (s *session) Write(b []byte) (int, error) {
+	if err := s.conn.WriteLine(strings.TrimSuffix(string(b), "\n")); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (s *session) joined(c *Chatroom) bool {
+	return s.p != nil && s.p.CurrentRoom() == c
+}
+
+func (f *Frontend) serve(conn lineConn) {
+	f.cmu.Lock()
+	f.conns[conn] = struct{}{}
+	f.cmu.Unlock()
+
+	s := &session{conn: conn}
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			break
+		}
+		reply, quit := f.handle(s, line)
+		if conn.WriteLine(reply) != nil || quit {
+			break
+		}
+	}
+
+	if s.joined(f.room) {
+		f.room.Leave(s.p)
+	}
+	f.cmu.Lock()
+	delete(f.conns, conn)
+	f.cmu.Unlock()
+	conn.Close()
+}
+
+// handle runs one command and gives the reply for it.
+func (f *Frontend) handle(s *session, line string) (reply string, quit bool) {
+	cmd, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+	cmd = strings.ToUpper(cmd)
+	if cmd != "JOIN" && cmd != "QUIT" && cmd != "FORMAT" && !s.joined(f.room) {
+		return "ERR join first", false
+	}
+	var err error
+	switch cmd {
+	case "JOIN":
+		if s.joined(f.room) {
+			return "ERR already joined", false
+		}
+		if rest == "" || strings.ContainsAny(rest, " \t") {
+			return "ERR usage: JOIN <name>", false
+		}
+		p := NewPerson(rest)
+		p.Out, p.Renderer = s, s.renderer
+		if err = f.room.Join(p); err == nil {
+			s.p = p
+		}
+	case "SAY":
+		err = s.p.Say(rest)
+	case "MSG":
+		who, text, ok := strings.Cut(rest, " ")
+		if !ok {
+			return "ERR usage: MSG <who> <text>", false
+		}
+		err = s.p.PrivateMessage(who, text)
+	case "LEAVE":
+		f.room.Leave(s.p)
+		s.p = nil
+	case "FORMAT":
+		r, ok := renderers[strings.ToLower(rest)]
+		if !ok {
+			return "ERR usage: FORMAT plain|json|ansi", false
+		}
+		s.renderer = r
+		if s.p != nil {
+			s.p.SetRenderer(r)
+		}
+	case "HISTORY":
+		err = f.sendHistory(s, rest)
+	case "PRESENCE":
+		var pr Presence
+		if pr.UnmarshalText([]byte(strings.ToLower(rest))) != nil {
+			return "ERR usage: PRESENCE online|away|offline", false
+		}
+		s.p.SetPresence(pr)
+	case "TYPING":
+		err = s.p.Typing()
+	case "READ", "READBY":
+		id, perr := strconv.ParseUint(rest, 10, 64)
+		if perr != nil {
+			return "ERR usage: " + cmd + " <id>", false
+		}
+		if cmd == "READ" {
+			err = s.p.Read(Message{Room: f.room.Name, ID: id})
+			break
+		}
+		var who []string
+		if who, err = f.room.ReadBy(id); err == nil {
+			err = s.conn.WriteLine(fmt.Sprintf("READBY %d: %s", id, strings.Join(who, ", ")))
+		}
+	case "QUIT":
+		return "OK bye", true
+	default:
+		return "ERR unknown command " + cmd, false
+	}
+	if err != nil {
+		return "ERR " + err.Error(), false
+	}
+	return "OK", false
+}
+
+// maxHistoryPage caps the n of HISTORY, a client can't have the whole
+// history in one go (the next page is just a HISTORY away).
+const maxHistoryPage = 100
+
+func (f *Frontend) sendHistory(s *session, args string) error {
+	before, n := uint64(0), 20
+	var err error
+	a := strings.Fields(args)
+	if len(a) > 0 {
+		before, err = strconv.ParseUint(a[0], 10, 64)
+	}
+	if len(a) > 1 && err == nil {
+		n, err = strconv.Atoi(a[1])
+	}
+	if err != nil || len(a) > 2 || n <= 0 {
+		return errors.New("usage: HISTORY [before] [n]")
+	}
+	n = min(n, maxHistoryPage)
+	page, err := f.room.History(before, n)
+	if err != nil {
+		return err
+	}
+	for _, m := range page {
+		line := fmt.Sprintf("HIST %d %s %s: %s", m.ID, m.Time.UTC().Format(time.RFC3339), m.From(), m.Text)
+		if err := s.conn.WriteLine(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riteshharjani/design-pattens-go/Mediator/websocket"
+)
+
+// startFrontend serves room over tcp and websocket on the loopback, and
+// gives the two addresses.
+func startFrontend(t *testing.T, room *Chatroom) (tcpAddr, httpAddr string) {
+	t.Helper()
+	fe := NewFrontend(room)
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go fe.ServeTCP(tl)
+	go http.Serve(hl, fe)
+	t.Cleanup(func() {
+		tl.Close()
+		hl.Close()
+		fe.Close()
+	})
+	return tl.Addr().String(), hl.Addr().String()
+}
+
+type tcpClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialTCP(t *testing.T, addr string) *tcpClient {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return &tcpClient{t, c, bufio.NewReader(c)}
+}
+
+func (c *tcpClient) send(line string) {
+	c.t.Helper()
+	if _, err := fmt.Fprint(c.conn, line+"\r\n"); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *tcpClient) expect(want string) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("tcp: reading %q: %v", want, err)
+	}
+	if got = strings.TrimSuffix(got, "\n"); got != want {
+		c.t.Fatalf("tcp: got %q, want %q", got, want)
+	}
+}
+
+type wsClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dialWS(t *testing.T, addr string) *wsClient {
+	t.Helper()
+	c, err := websocket.Dial("ws://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return &wsClient{t, c}
+}
+
+func (c *wsClient) send(line string) {
+	c.t.Helper()
+	if err := c.conn.WriteMessage(line); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsClient) expect(want string) {
+	c.t.Helper()
+	got, err := c.conn.ReadMessage()
+	if err != nil {
+		c.t.Fatalf("ws: reading %q: %v", want, err)
+	}
+	if got != want {
+		c.t.Fatalf("ws: got %q, want %q", got, want)
+	}
+}
+
+func TestFrontendSession(t *testing.T) {
+	tcpAddr, httpAddr := startFrontend(t, &Chatroom{})
+	alice := dialTCP(t, tcpAddr)
+	bob := dialWS(t, httpAddr)
+
+	alice.send("SAY too early")
+	alice.expect("ERR join first")
+	alice.send("JOIN")
+	alice.expect("ERR usage: JOIN <name>")
+	alice.send("JOIN Alice")
+	alice.expect("OK")
+	alice.send("JOIN Alice")
+	alice.expect("ERR already joined")
+
+	bob.send("JOIN Alice")
+	bob.expect("ERR name already taken in this room")
+	bob.send("JOIN Bob")
+	bob.expect("OK")
+	alice.expect("[Alice's chat session]: Room: Bob joins the chat")
+
+	alice.send("SAY hi bob")
+	alice.expect("OK")
+	bob.expect("[Bob's chat session]: Alice: hi bob")
+
+	bob.send("MSG Alice psst")
+	bob.expect("OK")
+	alice.expect("[Alice's chat session]: Bob (private): psst")
+	bob.send("MSG Carol hello?")
+	bob.expect("ERR no such person in the room")
+	bob.send("MSG Alice")
+	bob.expect("ERR usage: MSG <who> <text>")
+
+	alice.send("bogus")
+	alice.expect("ERR unknown command BOGUS")
+	alice.send("FORMAT xml")
+	alice.expect("ERR usage: FORMAT plain|json|ansi")
+	alice.send("READ one")
+	alice.expect("ERR usage: READ <id>")
+	alice.send("PRESENCE asleep")
+	alice.expect("ERR usage: PRESENCE online|away|offline")
+	alice.send("HISTORY x")
+	alice.expect("ERR usage: HISTORY [before] [n]")
+
+	// LEAVE keeps the connection, and JOIN works again on it
+	bob.send("LEAVE")
+	bob.expect("OK")
+	alice.expect("[Alice's chat session]: Room: Bob leaves the chat")
+	bob.send("SAY anyone?")
+	bob.expect("ERR join first")
+	bob.send("JOIN Bob")
+	bob.expect("OK")
+	alice.expect("[Alice's chat session]: Room: Bob joins the chat")
+
+	// QUIT leaves, and hangs up
+	bob.send("QUIT")
+	bob.expect("OK bye")
+	if _, err := bob.conn.ReadMessage(); err != io.EOF {
+		t.Fatalf("ws after QUIT: %v, want io.EOF", err)
+	}
+	alice.expect("[Alice's chat session]: Room: Bob leaves the chat")
+	alice.send("QUIT")
+	alice.expect("OK bye")
+	if _, err := alice.r.ReadString('\n'); err != io.EOF {
+		t.Fatalf("tcp after QUIT: %v, want io.EOF", err)
+	}
+}
+
+// a hung up connection leaves the room too.
+func TestFrontendHangUp(t *testing.T) {
+	room := &Chatroom{}
+	tcpAddr, httpAddr := startFrontend(t, room)
+	alice := dialTCP(t, tcpAddr)
+	bob := dialWS(t, httpAddr)
+	alice.send("JOIN Alice")
+	alice.expect("OK")
+	bob.send("JOIN Bob")
+	bob.expect("OK")
+	alice.expect("[Alice's chat session]: Room: Bob joins the chat")
+
+	bob.conn.Close()
+	alice.expect("[Alice's chat session]: Room: Bob leaves the chat")
+	alice.conn.Close()
+	for i := 0; len(room.Members()) > 0; i++ {
+		if i == 500 {
+			t.Fatalf("still in the room: %v", room.Members())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// rawWS is a websocket client which writes it's frames by hand, to send
+// what websocket.Conn never does: fragments, and pings in between them.
+type rawWS struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRawWS(t *testing.T, addr string) *rawWS {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: %s\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", addr)
+	r := bufio.NewReader(c)
+	resp, err := http.ReadResponse(r, &http.Request{Method: http.MethodGet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// the example key and accept of RFC 6455
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake: %s %v", resp.Status, resp.Header)
+	}
+	return &rawWS{t, c, r}
+}
+
+// frame writes one masked frame, as a client has to.
+func (c *rawWS) frame(fin bool, op byte, payload string) {
+	c.t.Helper()
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	var mask [4]byte
+	rand.Read(mask[:])
+	buf := append([]byte{b0, 0x80 | byte(len(payload))}, mask[:]...)
+	for i := range len(payload) {
+		buf = append(buf, payload[i]^mask[i%4])
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// next reads one (short, unmasked) frame from the server.
+func (c *rawWS) next() (fin bool, op byte, payload string, err error) {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.r, hdr[:]); err != nil {
+		return
+	}
+	n := int(hdr[1] & 0x7f)
+	if n == 126 {
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	buf := make([]byte, n)
+	_, err = io.ReadFull(c.r, buf)
+	return hdr[0]&0x80 != 0, hdr[0] & 0x0f, string(buf), err
+}
+
+func (c *rawWS) expect(op byte, want string) {
+	c.t.Helper()
+	fin, gotOp, got, err := c.next()
+	if err != nil {
+		c.t.Fatalf("raw ws: reading %q: %v", want, err)
+	}
+	if !fin || gotOp != op || got != want {
+		c.t.Fatalf("raw ws: got fin=%v op=%#x %q, want op=%#x %q", fin, gotOp, got, op, want)
+	}
+}
+
+func TestFrontendWebSocketFrames(t *testing.T) {
+	tcpAddr, httpAddr := startFrontend(t, &Chatroom{})
+	dave := dialTCP(t, tcpAddr)
+	dave.send("JOIN Dave")
+	dave.expect("OK")
+
+	carl := dialRawWS(t, httpAddr)
+	// "JOIN Carl" in two fragments, with a ping in between
+	carl.frame(false, 0x1, "JOIN ")
+	carl.frame(true, 0x9, "are you there")
+	carl.frame(true, 0x0, "Carl")
+	carl.expect(0xa, "are you there")
+	carl.expect(0x1, "OK")
+	dave.expect("[Dave's chat session]: Room: Carl joins the chat")
+
+	// three fragments, a ping and a pong (which is ignored) in between
+	carl.frame(false, 0x1, "SAY hel")
+	carl.frame(true, 0xa, "unasked")
+	carl.frame(false, 0x0, "lo ")
+	carl.frame(true, 0x9, "")
+	carl.frame(true, 0x0, "dave")
+	carl.expect(0xa, "")
+	carl.expect(0x1, "OK")
+	dave.expect("[Dave's chat session]: Carl: hello dave")
+
+	// a continuation with nothing to continue ends the connection
+	carl.frame(true, 0x0, "what?")
+	dave.expect("[Dave's chat session]: Room: Carl leaves the chat")
+	for {
+		_, op, _, err := carl.next()
+		if err != nil || op == 0x8 {
+			break // a close frame, or just hung up
+		}
+	}
+
+	// and a client which does not mask is a protocol error too
+	eve := dialRawWS(t, httpAddr)
+	eve.conn.Write([]byte{0x81, 4, 'Q', 'U', 'I', 'T'})
+	eve.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	rest, _ := io.ReadAll(eve.r)
+	if bytes.Contains(rest, []byte("OK bye")) {
+		t.Fatal("unmasked frame was accepted")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sync"
+)
+
+// The chat log of a person lives only as long as the person, and whoever
+// joins late sees nothing of what was said before. So a room can also keep
+// it's history in a Store: every Message sent in it, which already has an
+// ID (from the room, going up and never reused) and a timestamp. Msgs for
+// some people only (private ones, the answer to /who ...) are not kept.
+//
+// There are 2 stores here: MemoryStore (the last N in a ring buffer) and
+// FileStore (an append-only file, so it survives a restart). One on top of a
+// database is the same 3 methods, but this repo has only the std library and
+// nothing to test it against, so it is left out.
+
+type Store interface {
+	// Append keeps m, whose ID is above any kept so far.
+	Append(m Message) error
+	// Page gives (at most) n msgs with an ID below before, the newest n of
+	// them but oldest first. before 0 means from the very end, and the first
+	// ID of a page is the before of the page preceding it.
+	Page(before uint64, n int) ([]Message, error)
+	Close() error
+}
+
+// MemoryStore keeps the last size msgs.
+type MemoryStore struct {
+	mu   sync.Mutex
+	ring []Message
+	next int // where the next one goes in ring
+}
+
+func NewMemoryStore(size int) *MemoryStore {
+	return &MemoryStore{ring: make([]Message, 0, max(size, 1))}
+}
+
+func (s *MemoryStore) Append(m Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.ring) < cap(s.ring) {
+		s.ring = append(s.ring, m)
+	} else {
+		s.ring[s.next] = m
+	}
+	s.next = (s.next + 1) % cap(s.ring)
+	return nil
+}
+
+func (s *MemoryStore) Page(before uint64, n int) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if before == 0 {
+		before = math.MaxUint64
+	}
+	var page []Message
+	// newest first, starting just before next
+	for i := 1; i <= len(s.ring) && len(page) < n; i++ {
+		m := s.ring[(s.next-i+len(s.ring))%len(s.ring)]
+		if m.ID < before {
+			page = append(page, m)
+		}
+	}
+	slices.Reverse(page)
+	return page, nil
+}
+
+func (s *MemoryStore) Close() error { return nil }
+
+// FileStore writes one json msg per line, and remembers the ID and where
+// each line starts, so a page is read w/o going through the whole file.
+type FileStore struct {
+	mu      sync.Mutex
+	f       *os.File
+	ids     []uint64 // of the msgs in the file, in order
+	offsets []int64  // offsets[i] is where the line of ids[i] starts
+	end     int64
+}
+
+func OpenFileStore(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{f: f}
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			break // a last line w/o a newline is a write cut short, drop it
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		var m struct {
+			ID uint64 `json:"id"`
+		}
+		if err := json.Unmarshal(line, &m); err != nil {
+			f.Close()
+			return nil, err
+		}
+		s.ids = append(s.ids, m.ID)
+		s.offsets = append(s.offsets, s.end)
+		s.end += int64(len(line))
+	}
+	if err := f.Truncate(s.end); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) Append(m Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	line, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := s.f.WriteAt(line, s.end); err != nil {
+		return err
+	}
+	s.ids = append(s.ids, m.ID)
+	s.offsets = append(s.offsets, s.end)
+	s.end += int64(len(line))
+	return nil
+}
+
+func (s *FileStore) Page(before uint64, n int) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hi := len(s.ids) // the page is the n lines before hi
+	if before != 0 {
+		hi, _ = slices.BinarySearch(s.ids, before)
+	}
+	lo := max(hi-n, 0)
+	if lo >= hi {
+		return nil, nil
+	}
+	// the lines are next to each other in the file
+	start, end := s.offsets[lo], s.end
+	if hi < len(s.ids) {
+		end = s.offsets[hi]
+	}
+	buf := make([]byte, end-start)
+	if _, err := s.f.ReadAt(buf, start); err != nil {
+		return nil, err
+	}
+	page := make([]Message, 0, hi-lo)
+	for _, line := range bytes.SplitAfter(buf, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var m Message
+		if err := json.Unmarshal(line, &m); err != nil {
+			return nil, err
+		}
+		page = append(page, m)
+	}
+	return page, nil
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func said(id uint64) Message {
+	return Message{ID: id, Kind: Chat, Sender: "Ann", Time: time.Unix(int64(id), 0).UTC(), Text: fmt.Sprint("msg ", id)}
+}
+
+func ids(page []Message) []uint64 {
+	var ids []uint64
+	for _, m := range page {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+// stores gives a fresh store of each kind.
+func stores(t *testing.T) map[string]Store {
+	fs, err := OpenFileStore(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return map[string]Store{"memory": NewMemoryStore(100), "file": fs}
+}
+
+// paging back from the end, the first ID of each page as the before of the
+// next, goes through every msg once. The IDs have gaps (like the ones of
+// msgs not kept), so a before is not always one of them.
+func TestStorePaging(t *testing.T) {
+	for name, s := range stores(t) {
+		for id := uint64(2); id <= 50; id += 2 {
+			if err := s.Append(said(id)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var got []uint64
+		before := uint64(0)
+		for {
+			page, err := s.Page(before, 7)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			if len(page) > 7 {
+				t.Fatalf("%s: page of %d", name, len(page))
+			}
+			for i, m := range page {
+				if before != 0 && m.ID >= before || i > 0 && m.ID <= page[i-1].ID {
+					t.Fatalf("%s: page %v before %d", name, ids(page), before)
+				}
+				if m.Text != fmt.Sprint("msg ", m.ID) || !m.Time.Equal(time.Unix(int64(m.ID), 0)) {
+					t.Fatalf("%s: got %+v", name, m)
+				}
+			}
+			got = append(ids(page), got...)
+			before = page[0].ID
+		}
+		if len(got) != 25 || got[0] != 2 || got[24] != 50 {
+			t.Fatalf("%s: paged through %v", name, got)
+		}
+
+		if page, _ := s.Page(21, 3); fmt.Sprint(ids(page)) != "[16 18 20]" {
+			t.Fatalf("%s: Page(21, 3) = %v", name, ids(page))
+		}
+		if page, _ := s.Page(20, 3); fmt.Sprint(ids(page)) != "[14 16 18]" {
+			t.Fatalf("%s: Page(20, 3) = %v", name, ids(page))
+		}
+		if page, _ := s.Page(2, 3); len(page) != 0 {
+			t.Fatalf("%s: Page(2, 3) = %v", name, ids(page))
+		}
+		for _, n := range []int{0, -1} {
+			if page, err := s.Page(0, n); err != nil || len(page) != 0 {
+				t.Fatalf("%s: Page(0, %d) = %v, %v", name, n, ids(page), err)
+			}
+		}
+	}
+}
+
+// once full, the ring drops the oldest.
+func TestMemoryStoreWrap(t *testing.T) {
+	s := NewMemoryStore(5)
+	for id := uint64(1); id <= 12; id++ {
+		s.Append(said(id))
+	}
+	for _, c := range []struct {
+		before uint64
+		n      int
+		want   string
+	}{
+		{0, 10, "[8 9 10 11 12]"},
+		{0, 2, "[11 12]"},
+		{11, 2, "[9 10]"},
+		{10, 10, "[8 9]"},
+		{8, 10, "[]"},
+	} {
+		if page, _ := s.Page(c.before, c.n); fmt.Sprint(ids(page)) != c.want {
+			t.Fatalf("Page(%d, %d) = %v, want %s", c.before, c.n, ids(page), c.want)
+		}
+	}
+}
+
+// a write cut short leaves a last line w/o a newline, which is dropped on
+// open, and the next msg goes where it was.
+func TestFileStoreTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := uint64(1); id <= 3; id++ {
+		s.Append(said(id))
+	}
+	s.Close()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":4,"kind":"cha`)
+	f.Close()
+
+	if s, err = OpenFileStore(path); err != nil {
+		t.Fatal(err)
+	}
+	if page, _ := s.Page(0, 10); fmt.Sprint(ids(page)) != "[1 2 3]" {
+		t.Fatalf("after reopen: %v", ids(page))
+	}
+	if err := s.Append(said(4)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if s, err = OpenFileStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if page, err := s.Page(0, 10); err != nil || fmt.Sprint(ids(page)) != "[1 2 3 4]" {
+		t.Fatalf("after the next append: %v, %v", ids(page), err)
+	}
+}
+
+// HISTORY wants an n above 0, and gives no more than maxHistoryPage.
+func TestFrontendHistoryLimit(t *testing.T) {
+	store := NewMemoryStore(300)
+	for id := uint64(1); id <= 150; id++ {
+		store.Append(said(id))
+	}
+	tcpAddr, _ := startFrontend(t, (&Chatroom{}).WithHistory(store, 0))
+	c := dialTCP(t, tcpAddr)
+	c.send("JOIN Bob")
+	c.expect("OK")
+	for _, n := range []string{"0", "-1"} {
+		c.send("HISTORY 0 " + n)
+		c.expect("ERR usage: HISTORY [before] [n]")
+	}
+
+	// Bob joining is msg 151
+	c.send("HISTORY 0 1000000")
+	for id := 151 - maxHistoryPage + 1; id <= 151; id++ {
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(line, fmt.Sprintf("HIST %d ", id)) {
+			t.Fatalf("got %q, want msg %d", line, id)
+		}
+	}
+	c.expect("OK")
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Everything people say (Broadcast and Message) goes through a chain of
+// interceptors before the room sends it, much like http middleware. Each one
+// gets the msg and the rest of the chain (next), and can
+//
+//   - pass it on:        return next(m)
+//   - rewrite it:        m.Text = ...; return next(m)
+//   - turn it down:      return someErr, w/o calling next
+//   - fan it out:        call next more than once, e.g. with a reply
+//   - hold it back:      sleep, and then call next
+//
+// What the room itself announces (joins, leaves ...) does not go through the
+// chain. The error ends up with whoever said it, e.g. from Person.Say.
+//
+// The chain runs on the go routine of the sender, w/o holding the room, so
+// an interceptor may take it's time (and must look after it's own state, as
+// many senders may be in it at once).
+
+type Next func(m Message) error
+
+type Interceptor func(c *Chatroom, m Message, next Next) error
+
+// Use adds interceptors to the end of the chain, so the first one added is
+// the first to see a msg.
+func (c *Chatroom) Use(is ...Interceptor) *Chatroom {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// a new slice, a chain which is running keeps the old one
+	c.interceptors = append(slices.Clip(c.interceptors), is...)
+	return c
+}
+
+// submit runs m through the chain, at the end of which it is sent.
+func (c *Chatroom) submit(m Message) error {
+	c.mu.Lock()
+	is := c.interceptors
+	c.mu.Unlock()
+	return c.chain(is, 0)(m)
+}
+
+func (c *Chatroom) chain(is []Interceptor, i int) Next {
+	if i == len(is) {
+		return func(m Message) error {
+			c.mu.Lock()
+			defer c.unlock()
+			c.send(m)
+			return nil
+		}
+	}
+	return func(m Message) error {
+		return is[i](c, m, c.chain(is, i+1))
+	}
+}
+
+var (
+	ErrRateLimited    = errors.New("slow down, too many msgs")
+	ErrUnknownCommand = errors.New("unknown command")
+)
+
+// Profanity blots out words (whole words, any case) with stars.
+func Profanity(words ...string) Interceptor {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	re := regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+	return func(c *Chatroom, m Message, next Next) error {
+		m.Text = re.ReplaceAllStringFunc(m.Text, func(w string) string {
+			return strings.Repeat("*", len(w))
+		})
+		return next(m)
+	}
+}
+
+// RateLimit lets every sender say n things per period, and a few more after
+// keeping quiet for a while (a token bucket which fills up again over period).
+func RateLimit(n int, period time.Duration) Interceptor {
+	l := newRateLimiter(n, period)
+	return func(c *Chatroom, m Message, next Next) error {
+		p := c.person(m.Sender)
+		if p == nil {
+			return next(m) // not from someone in the room
+		}
+		if !l.take(p, time.Now()) {
+			return ErrRateLimited
+		}
+		return next(m)
+	}
+}
+
+type rateLimiter struct {
+	n      float64
+	rate   float64 // tokens per second
+	period time.Duration
+
+	mu sync.Mutex
+	// by person rather than name, or a /nick would start afresh (and leaving
+	// and coming back doesn't either)
+	buckets map[*Person]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(n int, period time.Duration) *rateLimiter {
+	return &rateLimiter{
+		n: float64(n), rate: float64(n) / period.Seconds(), period: period,
+		buckets: map[*Person]*bucket{}, swept: time.Now(),
+	}
+}
+
+// fill is how many tokens b has at now.
+func (l *rateLimiter) fill(b *bucket, now time.Time) float64 {
+	return min(l.n, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+}
+
+func (l *rateLimiter) take(p *Person, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[p]
+	if !ok {
+		b = &bucket{tokens: l.n, last: now}
+		l.buckets[p] = b
+	}
+	b.tokens, b.last = l.fill(b, now), now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep drops the buckets which are full again, as a full one is no different
+// from none. Otherwise every sender ever (gone or not) would stay in buckets.
+// Once a period is enough, by then everyone quiet for that long is full.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.period {
+		return
+	}
+	l.swept = now
+	for p, b := range l.buckets {
+		if l.fill(b, now) >= l.n {
+			delete(l.buckets, p)
+		}
+	}
+}
+
+// SlashCommands understands a chat msg which starts with a /:
+//
+//	/me <does something>   an action, shown as "* John does something"
+//	/nick <name>           change name, in every room you are in
+//	/who                   who is in the room, told just to the sender
+//
+// Any other /command is turned down, and "//" says a msg starting with /.
+func SlashCommands() Interceptor {
+	return func(c *Chatroom, m Message, next Next) error {
+		if m.Kind != Chat || !strings.HasPrefix(m.Text, "/") {
+			return next(m)
+		}
+		if strings.HasPrefix(m.Text, "//") {
+			m.Text = m.Text[1:]
+			return next(m)
+		}
+		cmd, arg, _ := strings.Cut(m.Text[1:], " ")
+		arg = strings.TrimSpace(arg)
+		switch cmd {
+		case "me":
+			m.Text = arg
+			return next(m.WithMeta("action", "me"))
+		case "nick":
+			p := c.person(m.Sender)
+			if p == nil || arg == "" || strings.ContainsAny(arg, " \t") {
+				return errors.New("usage: /nick <name>")
+			}
+			return rename(p, arg)
+		case "who":
+			return next(Message{Kind: System, To: []string{m.Sender},
+				Text: "here: " + strings.Join(c.Members(), ", ")})
+		}
+		return fmt.Errorf("%w /%s", ErrUnknownCommand, cmd)
+	}
+}
+
+// Audit logs every msg which comes by, and whether the rest of the chain let
+// it through. The time (if any) is up to the flags of l.
+func Audit(l *log.Logger) Interceptor {
+	return func(c *Chatroom, m Message, next Next) error {
+		err := next(m)
+		outcome := "ok"
+		if err != nil {
+			outcome = "rejected: " + err.Error()
+		}
+		to := "#" + c.Name
+		if len(m.To) > 0 {
+			to = strings.Join(m.To, ",")
+		}
+		l.Printf("%s %s -> %s: %q %s", m.Kind, m.From(), to, m.Text, outcome)
+		return err
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// the answer to /who is for the one who asked, it is neither replayed to
+// whoever joins later nor in the history.
+func TestWhoIsNotKept(t *testing.T) {
+	room := NewChatroom(nil).WithHistory(NewMemoryStore(10), 10).Use(SlashCommands())
+	defer room.Close()
+	a := &Person{Name: "A", Out: io.Discard}
+	room.Join(a)
+	if err := a.Say("/who"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.PrivateMessage("A", "note to self"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Say("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(a.chatLog); n != 2 || a.chatLog[0].Text != "here: A" {
+		t.Fatalf("A got %v, want the /who answer and the private msg", a.chatLog)
+	}
+
+	b := &Person{Name: "B", Out: io.Discard}
+	room.Join(b)
+	for _, m := range b.chatLog {
+		if len(m.To) > 0 {
+			t.Fatalf("B got a msg for %v: %+v", m.To, m)
+		}
+	}
+	if n := len(b.chatLog); n != 2 || b.chatLog[1].Text != "hello" {
+		t.Fatalf("B got %v, want the join of A and hello", b.chatLog)
+	}
+
+	page, err := room.History(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range page {
+		if len(m.To) > 0 {
+			t.Fatalf("history has a msg for %v: %+v", m.To, m)
+		}
+	}
+	if len(page) != 3 { // A joins, hello, B joins
+		t.Fatalf("history has %d msgs, want 3: %v", len(page), page)
+	}
+}
+
+// leaving and coming back is no way around RateLimit, and once quiet for
+// long enough it's all forgotten.
+func TestRateLimitLeaveAndRejoin(t *testing.T) {
+	const period = 200 * time.Millisecond
+	room := NewChatroom(nil).Use(RateLimit(2, period))
+	defer room.Close()
+	a := &Person{Name: "A", Out: io.Discard}
+	room.Join(a)
+	for i := range 3 {
+		err := a.Say("hi")
+		if want := i == 2; errors.Is(err, ErrRateLimited) != want {
+			t.Fatalf("say %d: %v", i, err)
+		}
+	}
+	room.Leave(a)
+	room.Join(a)
+	if err := a.Say("back"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("after rejoining: %v, want ErrRateLimited", err)
+	}
+	time.Sleep(period)
+	if err := a.Say("back"); err != nil {
+		t.Fatalf("after a period: %v", err)
+	}
+}
+
+// the buckets of those who went quiet (and maybe left) are dropped, a full
+// bucket being the same as none.
+func TestRateLimitForgets(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+	a, b := &Person{Name: "A"}, &Person{Name: "B"}
+	now := l.swept
+	l.take(a, now)
+	l.take(a, now)
+	l.take(b, now.Add(30*time.Second))
+	if len(l.buckets) != 2 {
+		t.Fatalf("%d buckets, want 2", len(l.buckets))
+	}
+	// A is full again by then, B not yet
+	l.take(b, now.Add(time.Minute))
+	if _, ok := l.buckets[a]; ok || len(l.buckets) != 1 {
+		t.Fatalf("buckets %v, want just B's", l.buckets)
+	}
+	l.take(a, now.Add(3*time.Minute))
+	if _, ok := l.buckets[b]; ok || len(l.buckets) != 1 {
+		t.Fatalf("buckets %v, want just A's", l.buckets)
+	}
+}
@@ -1,78 +1,24 @@
 package main
 
-import "fmt"
-
-// e.g. of mediator design pattern is a simulation of chat room.
-// begin by definiting a Participant of chat room "Person"
-
-type Person struct { // this does not know anything about other people
-	Name string
-	// and it should have a pointer to mediator
-	// chat room allows diff people communicate with one another w/o
-	// being aware of one other person
-	Room    *Chatroom // this is a mediator
-	chatLog []string
-}
-
-func NewPerson(name string) *Person {
-	return &Person{Name: name}
-}
-
-// you should be able to recieve a msg from
-// a) another Person
-// b) or directly from Chat room about some system msgs
-func (p *Person) Receive(sender, message string) {
-	// format the msg
-	// whose chat session we are actually in
-	// and append in the chat log of the person
-	s := fmt.Sprintf("%s: %s\n", sender, message)
-	fmt.Printf(`[%s's chat session]: %s`, p.Name, s)
-
-	p.chatLog = append(p.chatLog, s)
-}
-
-// method from Person to say/chat a msg
-func (p *Person) Say(message string) {
-	// p.Room is out mediator
-	p.Room.Broadcast(p.Name, message)
-}
-
-func (p *Person) PrivateMessage(who, message string) {
-	p.Room.Message(p.Name, who, message)
-}
-
-// let's define room now.
-
-type Chatroom struct {
-	people []*Person // we could have added a map using the key as the name
-	// then the search would be O(1)
-}
-
-// let's define a way of Broadcasting
-func (c *Chatroom) Broadcast(source, message string) {
-	for _, p := range c.people {
-		if p.Name != source {
-			p.Receive(source, message)
-		}
-	}
-}
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
-// let's define a way of messaging one other
-func (c *Chatroom) Message(src, dst, msg string) {
-	for _, p := range c.people {
-		if p.Name == dst {
-			p.Receive(src, msg)
-		}
-	}
-}
+	"github.com/riteshharjani/design-pattens-go/Mediator/eventbus"
+	"github.com/riteshharjani/design-pattens-go/Mediator/websocket"
+)
 
-func (c *Chatroom) Join(p *Person) {
-	// let's say when anyone joins then we do a broadcast to everyone
-	joinMsg := p.Name + " joins the chat"
-	c.Broadcast("Room: ", joinMsg)
-	p.Room = c
-	c.people = append(c.people, p)
-}
+// The Person is in person.go, the Chatroom (our mediator) in chatroom.go and
+// the ways of getting a msg from the room to a person in delivery.go.
 
 func main() {
 	room := Chatroom{}
@@ -91,14 +37,369 @@ func main() {
 
 	jane.PrivateMessage("Simon", "Glad you could join us")
 	// o/p of above
-	// [John's chat session]: Room: : Jane joins the chat
+	// [John's chat session]: Room: Jane joins the chat
 	// [Jane's chat session]: John: hi room
 	// [John's chat session]: Jane: oh, hey john
-	// [John's chat session]: Room: : Simon joins the chat
-	// [Jane's chat session]: Room: : Simon joins the chat
+	// [John's chat session]: Room: Simon joins the chat
+	// [Jane's chat session]: Room: Simon joins the chat
 	// [John's chat session]: Simon: Hi everyone
 	// [Jane's chat session]: Simon: Hi everyone
-	// [Simon's chat session]: Jane: Glad you could join us
+	// [Simon's chat session]: Jane (private): Glad you could join us
 	// ^^^ only simon recieve this since it is a private msg
 
+	// a bot need not make sense of the text, every msg says what it is.
+	greeter := NewPerson("Greeter")
+	greeter.Out = io.Discard
+	greeter.OnReceive = func(m Message) {
+		if m.Kind == Join {
+			fmt.Println("greeter sees a join of", m.Meta["who"])
+		}
+	}
+	room.Join(greeter)
+	room.Join(NewPerson("Mary"))
+	john.Say("hi mary")
+	m := greeter.chatLog[1]
+	m.Time = time.Time{} // so that the o/p stays the same
+	JSON{}.Render(os.Stdout, greeter.Name, m)
+	// o/p of above
+	// [John's chat session]: Room: Greeter joins the chat
+	// [Jane's chat session]: Room: Greeter joins the chat
+	// [Simon's chat session]: Room: Greeter joins the chat
+	// [John's chat session]: Room: Mary joins the chat
+	// [Jane's chat session]: Room: Mary joins the chat
+	// [Simon's chat session]: Room: Mary joins the chat
+	// greeter sees a join of Mary
+	// [Jane's chat session]: John: hi mary
+	// [Simon's chat session]: John: hi mary
+	// [Mary's chat session]: John: hi mary
+	// {"id":10,"kind":"chat","sender":"John","time":"0001-01-01T00:00:00Z","text":"hi mary"}
+
+	// with mailboxes a slow reader no longer holds up the room. Slow takes
+	// 50ms over every msg, but Fast can still say 10 things w/o waiting. With
+	// DropOldest Slow misses the ones in between but always gets the latest.
+	busy := NewChatroom(NewMailboxDelivery(2, DropOldest))
+	fast, slow := NewPerson("Fast"), NewPerson("Slow")
+	reading := make(chan struct{}, 1)
+	slow.OnReceive = func(Message) {
+		select {
+		case reading <- struct{}{}:
+		default:
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	busy.Join(slow)
+	busy.Join(fast)
+	<-reading // Slow is now busy with the join msg
+
+	start := time.Now()
+	for i := 1; i <= 10; i++ {
+		fast.Say(fmt.Sprint("msg #", i))
+	}
+	took := time.Since(start)
+	busy.Close()
+	fmt.Println("10 msgs said in under 50ms:", took < 50*time.Millisecond,
+		"dropped:", busy.delivery.(*MailboxDelivery).Dropped())
+	// o/p of above
+	// [Slow's chat session]: Room: Fast joins the chat
+	// [Slow's chat session]: Fast: msg #9
+	// [Slow's chat session]: Fast: msg #10
+	// 10 msgs said in under 50ms: true dropped: 8
+
+	// a server with many rooms, where a bot sits in all of them at once.
+	srv := NewServer()
+	bot, alice, bob := NewPerson("Bot"), NewPerson("Alice"), NewPerson("Bob")
+	srv.Join(bot, "general")
+	srv.Join(bot, "ops")
+	srv.Join(alice, "general")
+	srv.Join(bob, "ops")
+	srv.SetTopic(bob, "ops", "deploy at 5")
+	bot.SayIn("ops", "build is green")
+	alice.Say("anyone around?")
+	srv.Leave(bob, "ops")
+	fmt.Println("rooms:", srv.Rooms(), "bot is in:", bot.Rooms())
+	for _, name := range srv.Rooms() {
+		fmt.Println(srv.Room(name))
+	}
+	fmt.Println("leave again:", srv.Leave(bob, "ops"), srv.Leave(alice, "ops"))
+	// o/p of above
+	// [Bot's chat session]: #general Room: Alice joins the chat
+	// [Bot's chat session]: #ops Room: Bob joins the chat
+	// [Bot's chat session]: #ops Room: Bob sets the topic to: deploy at 5
+	// [Bob's chat session]: #ops Room: Bob sets the topic to: deploy at 5
+	// [Bob's chat session]: #ops Bot: build is green
+	// [Bot's chat session]: #general Alice: anyone around?
+	// [Bot's chat session]: #ops Room: Bob leaves the chat
+	// rooms: [general ops] bot is in: [general ops]
+	// #general (Bot, Alice)
+	// #ops (Bot): deploy at 5
+	// leave again: not in that room not in that room
+
+	// names are unique in a room, people can be kicked or banned, and a
+	// private msg to nobody is an error rather than lost silently.
+	general := srv.Room("general")
+	_, err := srv.Join(NewPerson("Alice"), "general")
+	fmt.Println("2nd alice:", err)
+	fmt.Println("pm to bob:", alice.PrivateMessage("Bob", "are you there?"))
+	troll := NewPerson("Troll")
+	srv.Join(troll, "general")
+	general.Kick("Bot", "Troll")
+	srv.Join(troll, "general")
+	general.Ban("Bot", "Troll")
+	_, err = srv.Join(troll, "general")
+	fmt.Println("troll again:", err, "members:", general.Members())
+	// o/p of above
+	// 2nd alice: name already taken in this room
+	// pm to bob: no such person in the room
+	// [Bot's chat session]: #general Room: Troll joins the chat
+	// [Alice's chat session]: #general Room: Troll joins the chat
+	// [Bot's chat session]: #general Room: Troll was kicked by Bot
+	// [Alice's chat session]: #general Room: Troll was kicked by Bot
+	// [Troll's chat session]: #general Room: Troll was kicked by Bot
+	// [Bot's chat session]: #general Room: Troll joins the chat
+	// [Alice's chat session]: #general Room: Troll joins the chat
+	// [Bot's chat session]: #general Room: Troll was banned by Bot
+	// [Alice's chat session]: #general Room: Troll was banned by Bot
+	// [Troll's chat session]: #general Room: Troll was banned by Bot
+	// troll again: banned from this room members: [Bot Alice]
+
+	// the same over the network (see frontend.go and chatcli/), with Alice on
+	// plain tcp and Bob on a websocket, both on the loopback.
+	lobby := &Chatroom{}
+	fe := NewFrontend(lobby)
+	must := func(err error) {
+		if err != nil {
+			panic(err)
+		}
+	}
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	must(err)
+	go fe.ServeTCP(tl)
+	hl, err := net.Listen("tcp", "127.0.0.1:0")
+	must(err)
+	go http.Serve(hl, fe)
+
+	tc, err := net.Dial("tcp", tl.Addr().String())
+	must(err)
+	tr := bufio.NewReader(tc)
+	wc, err := websocket.Dial("ws://" + hl.Addr().String() + "/")
+	must(err)
+	// see frontend_test.go for the rest of the protocol
+	tcpSend := func(line string) { _, err := fmt.Fprintln(tc, line); must(err) }
+	tcpRecv := func() { l, err := tr.ReadString('\n'); must(err); fmt.Print("tcp <- ", l) }
+	wsSend := func(line string) { must(wc.WriteMessage(line)) }
+	wsRecv := func() { m, err := wc.ReadMessage(); must(err); fmt.Println("ws  <-", m) }
+
+	tcpSend("SAY too early")
+	tcpRecv()
+	tcpSend("JOIN Alice")
+	tcpRecv()
+	wsSend("JOIN Bob")
+	wsRecv()
+	tcpRecv()
+	tcpSend("SAY hi bob")
+	tcpRecv()
+	wsRecv()
+	wsSend("MSG Alice psst")
+	wsRecv()
+	tcpRecv()
+	wsSend("MSG Carol hello?")
+	wsRecv()
+	wsSend("QUIT")
+	wsRecv()
+	tcpRecv()
+	tcpSend("QUIT")
+	tcpRecv()
+	tl.Close()
+	hl.Close()
+	fe.Close()
+	// o/p of above
+	// tcp <- ERR join first
+	// tcp <- OK
+	// ws  <- OK
+	// tcp <- [Alice's chat session]: Room: Bob joins the chat
+	// tcp <- OK
+	// ws  <- [Bob's chat session]: Alice: hi bob
+	// ws  <- OK
+	// tcp <- [Alice's chat session]: Bob (private): psst
+	// ws  <- ERR no such person in the room
+	// ws  <- OK bye
+	// tcp <- [Alice's chat session]: Room: Bob leaves the chat
+	// tcp <- OK bye
+
+	// a room which keeps it's history in a file: a newcomer gets the last 2
+	// msgs, and after a restart the history can still be paged through.
+	dir, _ := os.MkdirTemp("", "chat")
+	defer os.RemoveAll(dir)
+	store, _ := OpenFileStore(filepath.Join(dir, "history.jsonl"))
+	kept := (&Chatroom{}).WithHistory(store, 2)
+	ann := NewPerson("Ann")
+	kept.Join(ann)
+	for _, m := range []string{"one", "two", "three"} {
+		ann.Say(m)
+	}
+	kept.Join(NewPerson("Late"))
+	kept.Close()
+
+	store, _ = OpenFileStore(filepath.Join(dir, "history.jsonl"))
+	for before := uint64(0); ; {
+		page, _ := store.Page(before, 2)
+		if len(page) == 0 {
+			break
+		}
+		fmt.Print("page:")
+		for _, r := range page {
+			fmt.Printf(" #%d %s: %s", r.ID, r.From(), r.Text)
+		}
+		fmt.Println()
+		before = page[0].ID
+	}
+	store.Close()
+	// o/p of above
+	// [Late's chat session]: (history) Ann: two
+	// [Late's chat session]: (history) Ann: three
+	// [Ann's chat session]: Room: Late joins the chat
+	// page: #4 Ann: three #5 Room: Late joins the chat
+	// page: #2 Ann: one #3 Ann: two
+	// page: #1 Room: Ann joins the chat
+
+	// a moderated room: what people say is logged, limited to 4 msgs a
+	// minute, may be a /command, and gets it's bad words blotted out.
+	mod := (&Chatroom{Name: "mod"}).Use(
+		Audit(log.New(os.Stdout, "audit: ", 0)),
+		RateLimit(4, time.Minute),
+		SlashCommands(),
+		Profanity("darn"),
+	)
+	kim, lee := NewPerson("Kim"), NewPerson("Lee")
+	mod.Join(kim)
+	mod.Join(lee)
+	for _, say := range []string{"darn it", "/me waves", "/who", "/nick Kimmy", "/dance", "one too many"} {
+		if err := kim.Say(say); err != nil {
+			fmt.Println("kim can't say", say+":", err)
+		}
+	}
+	fmt.Println("now called:", mod.Members())
+	// o/p of above
+	// [Kim's chat session]: #mod Room: Lee joins the chat
+	// [Lee's chat session]: #mod Kim: **** it
+	// audit: chat Kim -> #mod: "darn it" ok
+	// [Lee's chat session]: #mod * Kim waves
+	// audit: chat Kim -> #mod: "/me waves" ok
+	// [Kim's chat session]: #mod Room: here: Kim, Lee
+	// audit: chat Kim -> #mod: "/who" ok
+	// [Kimmy's chat session]: #mod Room: Kim is now known as Kimmy
+	// [Lee's chat session]: #mod Room: Kim is now known as Kimmy
+	// audit: chat Kim -> #mod: "/nick Kimmy" ok
+	// audit: chat Kimmy -> #mod: "/dance" rejected: slow down, too many msgs
+	// kim can't say /dance: slow down, too many msgs
+	// audit: chat Kimmy -> #mod: "one too many" rejected: slow down, too many msgs
+	// kim can't say one too many: slow down, too many msgs
+	// now called: [Kimmy Lee]
+
+	// all of the above is fine from many go routines at once, see
+	// chatroom_test.go: people join, leave, rename and talk all at the same
+	// time, and still get the msgs of each of the others in the order they
+	// were said.
+
+	// a support desk: besides the text, who is there, who is typing and who
+	// read what. None of these signals end up in the history.
+	desk := (&Chatroom{Name: "desk"}).WithHistory(NewMemoryStore(10), 0)
+	agent, customer := NewPerson("Agent"), NewPerson("Customer")
+	desk.Join(agent)
+	desk.Join(customer)
+	agent.SetPresence(Away)
+	customer.Typing()
+	customer.Say("my order is late")
+	agent.SetPresence(Online)
+	question := agent.chatLog[len(agent.chatLog)-1]
+	agent.Read(question)
+	agent.Read(question) // only the first one counts
+	readBy, _ := desk.ReadBy(question.ID)
+	said, _ := desk.History(0, 10)
+	fmt.Println("read by:", readBy, "presence:", desk.Presence(), "kept:", len(said))
+	// o/p of above
+	// [Agent's chat session]: #desk Room: Customer joins the chat
+	// [Customer's chat session]: #desk * Agent is away
+	// [Agent's chat session]: #desk * Customer is typing
+	// [Agent's chat session]: #desk Customer: my order is late
+	// [Customer's chat session]: #desk * Agent is online
+	// [Customer's chat session]: #desk * Agent read #3
+	// read by: [Agent] presence: map[Agent:online Customer:online] kept: 3
+
+	// rooms on an eventbus, where anything can listen in or say something
+	// w/o being a Person (see bus.go and eventbus/). The bus itself knows
+	// nothing about chat.
+	bus := eventbus.New()
+	joins := eventbus.Subscribe(bus, "chat.*.join", func(topic string, m Message) {
+		fmt.Println(topic+":", m.Text)
+	})
+	seen := 0 // by the time the bus is closed
+	eventbus.SubscribeAsync(bus, "chat.>", 16, func(_ string, m Message) {
+		seen++
+	})
+	hub := NewServer()
+	hub.Bus = bus
+	hub.Join(NewPerson("Dev"), "incidents")
+	ctx := context.Background()
+	_, err = eventbus.Request[Message, struct{}](ctx, bus, "chat.incidents.say",
+		Message{Kind: Chat, Sender: "pager", Text: "disk full on db1"})
+	fmt.Println("pager said it:", err)
+	joins.Unsubscribe()
+	hub.Join(NewPerson("Ops"), "incidents")
+	members, _ := eventbus.Request[struct{}, []string](ctx, bus, "chat.incidents.members", struct{}{})
+	_, err = eventbus.Request[Message, struct{}](ctx, bus, "chat.nowhere.say", Message{Kind: Chat, Text: "?"})
+	bus.Close()
+	fmt.Println("members:", members, "nowhere:", err, "msgs seen:", seen)
+	// o/p of above
+	// chat.incidents.join: Dev joins the chat
+	// [Dev's chat session]: #incidents pager: disk full on db1
+	// pager said it: <nil>
+	// [Dev's chat session]: #incidents Room: Ops joins the chat
+	// members: [Dev Ops] nowhere: eventbus: nobody to answer the request msgs seen: 3
+
+	// two chat instances (as if in two services) sharing an incidents room,
+	// bridged over the loopback, see federation.go. The link breaks, and what
+	// is said meanwhile still gets across once it is back up.
+	roomA, roomB := &Chatroom{Name: "incidents"}, &Chatroom{Name: "incidents"}
+	bridgeA, bridgeB := NewBridge("svc-a", roomA), NewBridge("svc-b", roomB)
+	fl, err := net.Listen("tcp", "127.0.0.1:0")
+	must(err)
+	go bridgeB.Listen(fl)
+	var linkMu sync.Mutex
+	var link net.Conn // the latest one, so that it can be broken
+	bridgeA.Connect(func() (net.Conn, error) {
+		c, err := net.Dial("tcp", fl.Addr().String())
+		linkMu.Lock()
+		link = c
+		linkMu.Unlock()
+		return c, err
+	})
+	oncall, sre := NewPerson("Oncall"), NewPerson("SRE")
+	atA, atB := make(chan Message, 1), make(chan Message, 1)
+	oncall.OnReceive = func(m Message) { atA <- m }
+	sre.OnReceive = func(m Message) { atB <- m }
+	roomA.Join(oncall)
+	roomB.Join(sre)
+	for len(bridgeA.Peers()) == 0 || len(bridgeB.Peers()) == 0 {
+		time.Sleep(time.Millisecond) // the link is not up yet
+	}
+
+	oncall.Say("db1 is down")
+	<-atB
+	linkMu.Lock()
+	link.Close()
+	linkMu.Unlock()
+	oncall.Say("failing over to db2")
+	m = <-atB
+	fmt.Println("after reconnect:", m.Text, "from:", m.Meta["origin"])
+	sre.Say("on it")
+	<-atA
+	bridgeA.Close()
+	bridgeB.Close()
+	fl.Close()
+	// o/p of above
+	// [SRE's chat session]: #incidents Oncall@svc-a: db1 is down
+	// [SRE's chat session]: #incidents Oncall@svc-a: failing over to db2
+	// after reconnect: failing over to db2 from: svc-a
+	// [Oncall's chat session]: #incidents SRE@svc-b: on it
 }
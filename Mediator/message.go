@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// A msg used to be just (sender, message string) and was formatted into
+// text right away, so a system msg ended up as "Room: : Jane joins the chat"
+// and nobody (a bot say) could tell it from chat. Now a Message says what it
+// is, and a Renderer (render.go) turns it into text only at the very end.
+
+type Kind int
+
+const (
+	Chat    Kind = iota // someone said something
+	System              // the room says something, e.g. a new topic
+	Private             // to just one person
+	Join                // someone joins, Meta["who"] is who
+	Leave               // someone is gone, Meta["who"] and maybe Meta["reason"]
+
+	// and the signals, see presence.go. These have no ID and are never kept.
+	Status // the sender is online/away/offline, Meta["presence"] says which
+	Typing // the sender is typing
+	Read   // the sender read msg Meta["id"], only to whoever sent that
+)
+
+var kindNames = [...]string{"chat", "system", "private", "join", "leave", "status", "typing", "read"}
+
+// signal is true for the kinds which are not part of the conversation.
+func (k Kind) signal() bool {
+	return k == Status || k == Typing || k == Read
+}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *Kind) UnmarshalText(b []byte) error {
+	for i, n := range kindNames {
+		if n == string(b) {
+			*k = Kind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown message kind %q", b)
+}
+
+type Message struct {
+	ID     uint64            `json:"id"` // per room, given by the room in the order sent
+	Kind   Kind              `json:"kind"`
+	Room   string            `json:"room,omitempty"`
+	Sender string            `json:"sender,omitempty"` // empty when the room itself says it
+	To     []string          `json:"to,omitempty"`     // empty is everybody in the room
+	Time   time.Time         `json:"time"`
+	Text   string            `json:"text"`
+	Meta   map[string]string `json:"meta,omitempty"`
+}
+
+// From is who to show as the sender.
+func (m Message) From() string {
+	if m.Sender == "" {
+		return "Room"
+	}
+	return m.Sender
+}
+
+// WithMeta gives a copy of m with one more metadata key, m itself (which
+// others may be looking at) is left alone.
+func (m Message) WithMeta(key, val string) Message {
+	meta := make(map[string]string, len(m.Meta)+1)
+	for k, v := range m.Meta {
+		meta[k] = v
+	}
+	meta[key] = val
+	m.Meta = meta
+	return m
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+// e.g. of mediator design pattern is a simulation of chat room.
+// begin by definiting a Participant of chat room "Person"
+
+var ErrNotInRoom = errors.New("not in that room")
+
+type Person struct { // this does not know anything about other people
+	Name string
+	// and it should have a pointer to mediator
+	// chat room allows diff people communicate with one another w/o
+	// being aware of one other person
+	Room    *Chatroom // this is a mediator
+	chatLog []Message
+
+	// A person can be in many rooms at once (see server.go). Room above is
+	// just the current one, the one Say goes to, like the active window of
+	// an irc client. The rest are here by name.
+	rooms map[string]*Chatroom
+
+	// Out is where the chat session is shown, stdout if nil. For someone
+	// connected over the network it is the connection, see frontend.go.
+	// Renderer says how, PlainText if nil, see render.go.
+	Out      io.Writer
+	Renderer Renderer
+
+	// OnReceive, if set, is called for every msg after it is logged, e.g.
+	// for a bot which reacts to what is said. With a MailboxDelivery it runs
+	// on the person's own go routine, so it can take it's time.
+	OnReceive func(m Message)
+
+	presence Presence // see presence.go
+
+	// Once p is in a room, the rooms (and their deliveries) get at p from
+	// other go routines, so the fields above are guarded; set them before
+	// joining, or use the methods. There are 3 locks, none of them held for
+	// longer than it takes to get at a field, save wmu:
+	//
+	//   - rmu guards Name, Room and rooms, which the rooms change with their
+	//     own mu held (joined, left, rename). It is the only lock of p a
+	//     room ever takes.
+	//   - mu guards the rest.
+	//   - wmu is held by Receive while writing to Out, so msgs come out one
+	//     at a time and in order. For a network client that write can take
+	//     a while, which holds up p's own msgs and nothing else.
+	rmu sync.Mutex
+	mu  sync.Mutex
+	wmu sync.Mutex
+}
+
+func NewPerson(name string) *Person {
+	return &Person{Name: name}
+}
+
+// you should be able to recieve a msg from
+// a) another Person
+// b) or directly from Chat room about some system msgs
+func (p *Person) Receive(m Message) {
+	// render the msg for whose chat session we are actually in
+	// and append in the chat log of the person
+	name := p.name()
+	p.wmu.Lock()
+	p.mu.Lock()
+	out, r, onReceive := p.Out, p.Renderer, p.OnReceive
+	p.chatLog = append(p.chatLog, m)
+	p.mu.Unlock()
+	if out == nil {
+		out = os.Stdout
+	}
+	if r == nil {
+		r = PlainText{}
+	}
+	r.Render(out, name, m)
+	p.wmu.Unlock()
+
+	// w/o holding any lock, so it may say something right back
+	if onReceive != nil {
+		onReceive(m)
+	}
+}
+
+// method from Person to say/chat a msg, an error means it was not said
+// (e.g. an interceptor of the room turned it down).
+func (p *Person) Say(message string) error {
+	p.rmu.Lock()
+	room, name := p.Room, p.Name
+	p.rmu.Unlock()
+	if room == nil {
+		return ErrNotInRoom // not in a room (any more), nobody to say it to
+	}
+	// p.Room is out mediator
+	return room.Broadcast(name, message)
+}
+
+// PrivateMessage goes to who in the current room, it's an error if there
+// is nobody called that.
+func (p *Person) PrivateMessage(who, message string) error {
+	p.rmu.Lock()
+	room, name := p.Room, p.Name
+	p.rmu.Unlock()
+	if room == nil {
+		return ErrNotInRoom
+	}
+	return room.Message(name, who, message)
+}
+
+// SayIn says message in any of p's rooms, not just the current one.
+func (p *Person) SayIn(room, message string) error {
+	p.rmu.Lock()
+	c, ok := p.rooms[room]
+	name := p.Name
+	p.rmu.Unlock()
+	if !ok {
+		return ErrNotInRoom
+	}
+	return c.Broadcast(name, message)
+}
+
+// Switch makes room the current one.
+func (p *Person) Switch(room string) error {
+	p.rmu.Lock()
+	defer p.rmu.Unlock()
+	c, ok := p.rooms[room]
+	if !ok {
+		return ErrNotInRoom
+	}
+	p.Room = c
+	return nil
+}
+
+// CurrentRoom is Room, nil if p is in none.
+func (p *Person) CurrentRoom() *Chatroom {
+	p.rmu.Lock()
+	defer p.rmu.Unlock()
+	return p.Room
+}
+
+// In gives the room called name if p is in it, nil if not.
+func (p *Person) In(name string) *Chatroom {
+	p.rmu.Lock()
+	defer p.rmu.Unlock()
+	return p.rooms[name]
+}
+
+// SetRenderer changes how the msgs to come are shown.
+func (p *Person) SetRenderer(r Renderer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Renderer = r
+}
+
+// Rooms gives the names of all the rooms p is in, sorted.
+func (p *Person) Rooms() []string {
+	p.rmu.Lock()
+	defer p.rmu.Unlock()
+	return slices.Sorted(maps.Keys(p.rooms))
+}
+
+// the rest is for the rooms. joined and left are called by the room (with
+// it's mu held, so they take rmu only), joining makes it the current room.
+func (p *Person) joined(c *Chatroom) {
+	p.rmu.Lock()
+	defer p.rmu.Unlock()
+	if p.rooms == nil {
+		p.rooms = map[string]*Chatroom{}
+	}
+	p.rooms[c.Name] = c
+	p.Room = c
+}
+
+func (p *Person) left(c *Chatroom) {
+	p.rmu.Lock()
+	defer p.rmu.Unlock()
+	if p.rooms[c.Name] == c {
+		delete(p.rooms, c.Name)
+	}
+	if p.Room == c {
+		p.Room = nil // Switch to another one if need be
+	}
+}
+
+func (p *Person) roomList() []*Chatroom {
+	p.rmu.Lock()
+	defer p.rmu.Unlock()
+	return slices.Collect(maps.Values(p.rooms))
+}
+
+func (p *Person) name() string {
+	p.rmu.Lock()
+	defer p.rmu.Unlock()
+	return p.Name
+}
+
+// rename gives the old name.
+func (p *Person) rename(name string) string {
+	p.rmu.Lock()
+	defer p.rmu.Unlock()
+	old := p.Name
+	p.Name = name
+	return old
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Besides what people say, a support desk wants to know who is there at all,
+// who is typing an answer and who has read what. These are signals: msgs of
+// their own kinds (Status, Typing, Read) which go through the room like any
+// other, but get no ID, are never kept in the history and skip the
+// interceptors (there is no text to look at).
+//
+//   - presence: Online (the zero value, so everyone starts out online), Away
+//     or Offline. It belongs to the person, everybody in all of their rooms
+//     hears when it changes.
+//   - typing: told to everyone else in the room and then forgotten, a client
+//     shows it for a few seconds.
+//   - read receipts: a person tells the room they read a msg, and the room
+//     tells whoever sent it. The room also remembers who read each of the
+//     last receiptsKept msgs, see ReadBy.
+
+var ErrNoSuchMessage = errors.New("no such msg, or too old to say")
+
+type Presence int
+
+const (
+	Online Presence = iota
+	Away
+	Offline // still in their rooms, just not there
+)
+
+var presenceNames = [...]string{"online", "away", "offline"}
+
+func (pr Presence) String() string {
+	if pr < 0 || int(pr) >= len(presenceNames) {
+		return fmt.Sprintf("presence(%d)", int(pr))
+	}
+	return presenceNames[pr]
+}
+
+func (pr Presence) MarshalText() ([]byte, error) {
+	return []byte(pr.String()), nil
+}
+
+func (pr *Presence) UnmarshalText(b []byte) error {
+	for i, n := range presenceNames {
+		if n == string(b) {
+			*pr = Presence(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown presence %q", b)
+}
+
+// how many msgs back a room knows who read what.
+const receiptsKept = 1000
+
+// receipt is who sent a msg, who it was for (nil is everyone) and who read
+// it so far, in that order.
+type receipt struct {
+	from    *Person
+	to      []*Person
+	readers []string
+}
+
+// track starts a receipt for m, if it was said by someone in the room.
+func (c *Chatroom) track(m Message) {
+	if m.Kind != Chat && m.Kind != Private {
+		return
+	}
+	from := c.byName[m.Sender]
+	if from == nil {
+		return
+	}
+	r := &receipt{from: from}
+	for _, name := range m.To {
+		if p := c.byName[name]; p != nil {
+			r.to = append(r.to, p)
+		}
+	}
+	if c.receipts == nil {
+		c.receipts = map[uint64]*receipt{}
+	}
+	c.receipts[m.ID] = r
+	c.tracked = append(c.tracked, m.ID)
+	if len(c.tracked) > receiptsKept {
+		delete(c.receipts, c.tracked[0])
+		c.tracked = slices.Delete(c.tracked, 0, 1)
+	}
+}
+
+// Presence gives the presence of everyone in the room by name.
+func (c *Chatroom) Presence() map[string]Presence {
+	c.mu.Lock()
+	people := slices.Clone(c.people)
+	names := c.names()
+	c.mu.Unlock()
+	// the room never waits on a person with mu held
+	all := make(map[string]Presence, len(people))
+	for i, p := range people {
+		all[names[i]] = p.Presence()
+	}
+	return all
+}
+
+// presenceChanged tells everyone in the room but p that p is now pr.
+func (c *Chatroom) presenceChanged(p *Person, pr Presence) {
+	c.mu.Lock()
+	defer c.unlock()
+	if !slices.Contains(c.people, p) {
+		return // just left
+	}
+	c.send(Message{Kind: Status, Sender: p.Name, Text: "is " + pr.String(),
+		Meta: map[string]string{"presence": pr.String()}})
+}
+
+// Typing tells everyone else in the room that who is typing.
+func (c *Chatroom) Typing(who string) error {
+	c.mu.Lock()
+	defer c.unlock()
+	p := c.byName[who]
+	if p == nil {
+		return ErrNoSuchPerson
+	}
+	c.typing(p)
+	return nil
+}
+
+func (c *Chatroom) typing(p *Person) {
+	c.send(Message{Kind: Typing, Sender: p.Name, Text: "is typing"})
+}
+
+// MarkRead says who read the msg id, and lets the sender of it know. Reading
+// a msg again, or one's own, does nothing.
+func (c *Chatroom) MarkRead(who string, id uint64) error {
+	c.mu.Lock()
+	defer c.unlock()
+	p := c.byName[who]
+	if p == nil {
+		return ErrNoSuchPerson
+	}
+	return c.markRead(p, id)
+}
+
+func (c *Chatroom) markRead(p *Person, id uint64) error {
+	r, ok := c.receipts[id]
+	switch {
+	case ok && r.from == p:
+		return nil
+	// a private msg is only there for whoever it was for
+	case !ok || (r.to != nil && !slices.Contains(r.to, p)):
+		return ErrNoSuchMessage
+	case slices.Contains(r.readers, p.Name):
+		return nil
+	}
+	r.readers = append(r.readers, p.Name)
+	if slices.Contains(c.people, r.from) { // else nobody to tell
+		c.send(Message{Kind: Read, Sender: p.Name, To: []string{r.from.Name},
+			Text: "read #" + strconv.FormatUint(id, 10),
+			Meta: map[string]string{"id": strconv.FormatUint(id, 10)}})
+	}
+	return nil
+}
+
+// ReadBy gives who read the msg id so far, in the order they read it.
+func (c *Chatroom) ReadBy(id uint64) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.receipts[id]
+	if !ok {
+		return nil, ErrNoSuchMessage
+	}
+	return slices.Clone(r.readers), nil
+}
+
+func (p *Person) Presence() Presence {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.presence
+}
+
+// SetPresence changes p's presence, and tells all of p's rooms.
+func (p *Person) SetPresence(pr Presence) {
+	p.mu.Lock()
+	changed := p.presence != pr
+	p.presence = pr
+	p.mu.Unlock()
+	if !changed {
+		return
+	}
+	for _, c := range p.roomList() {
+		c.presenceChanged(p, pr)
+	}
+}
+
+// Typing tells the current room that p is typing.
+func (p *Person) Typing() error {
+	room := p.CurrentRoom()
+	if room == nil {
+		return ErrNotInRoom
+	}
+	room.mu.Lock()
+	defer room.unlock()
+	if !slices.Contains(room.people, p) {
+		return ErrNotInRoom
+	}
+	room.typing(p)
+	return nil
+}
+
+// Read sends a read receipt for m, in whichever of p's rooms it was said.
+func (p *Person) Read(m Message) error {
+	c := p.In(m.Room)
+	if c == nil {
+		return ErrNotInRoom
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	if !slices.Contains(c.people, p) {
+		return ErrNotInRoom
+	}
+	return c.markRead(p, m.ID)
+}
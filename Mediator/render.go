@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+)
+
+// A Renderer turns a Message into what a person sees, e.g. text for a
+// terminal or json for a bot. to is the name of whoever it is shown to.
+type Renderer interface {
+	Render(w io.Writer, to string, m Message) error
+}
+
+// text is the body of a msg in plain words, the same for every renderer
+// which prints text.
+func text(m Message) string {
+	s := ""
+	if m.Room != "" {
+		s = "#" + m.Room + " "
+	}
+	if m.Meta["replay"] != "" {
+		s += "(history) "
+	}
+	if m.Meta["action"] == "me" || m.Kind.signal() {
+		return s + "* " + m.From() + " " + m.Text // see SlashCommands, presence.go
+	}
+	s += m.From()
+	if m.Kind == Private {
+		s += " (private)"
+	}
+	return s + ": " + m.Text
+}
+
+// PlainText is the chat session lines we always had.
+type PlainText struct{}
+
+func (PlainText) Render(w io.Writer, to string, m Message) error {
+	_, err := fmt.Fprintf(w, "[%s's chat session]: %s\n", to, text(m))
+	return err
+}
+
+// JSON writes one json object per msg per line, for bots.
+type JSON struct{}
+
+func (JSON) Render(w io.Writer, to string, m Message) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// ANSI is PlainText in colour: the room in grey, private msgs in magenta and
+// every sender in a colour of their own (the same one every time).
+type ANSI struct{}
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiGrey    = "\x1b[90m"
+	ansiMagenta = "\x1b[35m"
+	ansiBold    = "\x1b[1m"
+)
+
+var senderColours = []string{"\x1b[31m", "\x1b[32m", "\x1b[33m", "\x1b[34m", "\x1b[36m"}
+
+func (ANSI) Render(w io.Writer, to string, m Message) error {
+	colour := ansiGrey
+	switch m.Kind {
+	case Chat:
+		h := fnv.New32a()
+		h.Write([]byte(m.Sender))
+		colour = senderColours[h.Sum32()%uint32(len(senderColours))]
+	case Private:
+		colour = ansiMagenta
+	}
+	_, err := fmt.Fprintf(w, "%s[%s]%s %s%s%s\n", ansiBold, to, ansiReset, colour, text(m), ansiReset)
+	return err
+}
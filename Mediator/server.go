@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/riteshharjani/design-pattens-go/Mediator/eventbus"
+)
+
+// One Chatroom is one mediator. A Server is the mediator of mediators: it
+// owns many named rooms, makes them when someone joins and throws them away
+// once the last person leaves. People still talk to the rooms directly, the
+// server is only about which rooms there are and who is in them. Who is
+// banned from a room is kept when it is thrown away, the same name gets the
+// same bans.
+//
+// A Server is safe for concurrent use too. It only holds it's own lock to
+// look up (or make, or throw away) a room, never while a room delivers.
+
+var ErrNoSuchRoom = errors.New("no such room")
+
+type Server struct {
+	mu    sync.Mutex
+	rooms map[string]*Chatroom
+	bans  map[string]map[string]bool // of the rooms thrown away, by room
+
+	// NewDelivery, if set, makes the Delivery of every new room, e.g.
+	//	func() Delivery { return NewMailboxDelivery(64, DropOldest) }
+	NewDelivery func() Delivery
+
+	// NewHistory, if set, gives the Store of every new room and how many
+	// msgs of it a newcomer gets, e.g. a FileStore per room name.
+	NewHistory func(room string) (Store, int)
+
+	// Bus, if set, is the eventbus every new room goes on, see bus.go.
+	Bus *eventbus.Bus
+}
+
+func NewServer() *Server {
+	return &Server{rooms: map[string]*Chatroom{}, bans: map[string]map[string]bool{}}
+}
+
+// Room gives the room called name, nil if there is none.
+func (s *Server) Room(name string) *Chatroom {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rooms[name]
+}
+
+// Rooms gives the names of all the rooms, sorted.
+func (s *Server) Rooms() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.rooms))
+}
+
+// Join puts p in the room called name, which is made if it is not there yet.
+// Joining a room p is already in does nothing.
+func (s *Server) Join(p *Person, name string) (*Chatroom, error) {
+	for {
+		c := s.room(name)
+		if p.In(name) == c {
+			return c, nil
+		}
+		err := c.Join(p)
+		if err == ErrRoomClosed {
+			continue // the last one just left, and a new room is needed
+		}
+		if err != nil {
+			s.dropIfEmpty(name, c) // made it just now, maybe
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// room gives the room called name, which is made if it is not there yet.
+func (s *Server) room(name string) *Chatroom {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.rooms[name]
+	if !ok {
+		c = &Chatroom{Name: name, banned: s.bans[name]}
+		delete(s.bans, name)
+		if s.NewDelivery != nil {
+			c.delivery = s.NewDelivery()
+		}
+		if s.NewHistory != nil {
+			c.WithHistory(s.NewHistory(name))
+		}
+		if s.Bus != nil {
+			c.WithBus(s.Bus)
+		}
+		s.rooms[name] = c
+	}
+	return c
+}
+
+// dropIfEmpty throws c away if nobody is in it. A Join which still has c
+// gets ErrRoomClosed from it, and tries again with a new room.
+func (s *Server) dropIfEmpty(name string, c *Chatroom) {
+	s.mu.Lock()
+	if s.rooms[name] != c {
+		s.mu.Unlock()
+		return
+	}
+	banned, ok := c.closeIfEmpty()
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	if len(banned) > 0 {
+		s.bans[name] = banned
+	}
+	delete(s.rooms, name)
+	s.mu.Unlock()
+	c.Close()
+}
+
+func (s *Server) Leave(p *Person, name string) error {
+	c := s.Room(name)
+	if c == nil {
+		return ErrNoSuchRoom
+	}
+	if !c.Leave(p) {
+		return ErrNotInRoom
+	}
+	s.dropIfEmpty(name, c)
+	return nil
+}
+
+// SetTopic changes the topic of a room, only someone in it can do that.
+func (s *Server) SetTopic(p *Person, name, topic string) error {
+	c := s.Room(name)
+	if c == nil {
+		return ErrNoSuchRoom
+	}
+	if p.In(name) != c {
+		return ErrNotInRoom
+	}
+	c.SetTopic(p.name(), topic)
+	return nil
+}
+
+func (s *Server) Members(name string) ([]string, error) {
+	c := s.Room(name)
+	if c == nil {
+		return nil, ErrNoSuchRoom
+	}
+	return c.Members(), nil
+}
+
+// Close closes every room, see Chatroom.Close.
+func (s *Server) Close() {
+	s.mu.Lock()
+	rooms := slices.Collect(maps.Values(s.rooms))
+	s.mu.Unlock()
+	for _, c := range rooms {
+		c.Close()
+	}
+}
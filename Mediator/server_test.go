@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+// a ban outlives the room being thrown away once everyone left.
+func TestServerKeepsBans(t *testing.T) {
+	s := NewServer()
+	mod := &Person{Name: "Mod", Out: io.Discard}
+	troll := &Person{Name: "Troll", Out: io.Discard}
+
+	c, err := s.Join(mod, "general")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Join(troll, "general"); err != nil {
+		t.Fatal(err)
+	}
+	c.Ban("Mod", "Troll")
+	if err := s.Leave(mod, "general"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Room("general") != nil {
+		t.Fatal("empty room was not thrown away")
+	}
+
+	// twice: the first failed Join makes (and throws away) a room too
+	for range 2 {
+		if _, err := s.Join(troll, "general"); err != ErrBanned {
+			t.Fatalf("Join after the room was dropped: %v, want ErrBanned", err)
+		}
+	}
+
+	c, err = s.Join(mod, "general")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Unban("Troll")
+	if _, err := s.Join(troll, "general"); err != nil {
+		t.Fatalf("Join after Unban: %v", err)
+	}
+
+	// and unbanned stays unbanned
+	s.Leave(mod, "general")
+	s.Leave(troll, "general")
+	if _, err := s.Join(troll, "general"); err != nil {
+		t.Fatalf("Join after Unban and a new room: %v", err)
+	}
+}